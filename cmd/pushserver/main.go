@@ -2,15 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/galihrivanto/go-zk/push"
+	"github.com/galihrivanto/go-zk/queue"
+	"github.com/galihrivanto/go-zk/webhook"
 )
 
-type hook struct{}
+type hook struct {
+	// bearer token required by management api
+	adminToken string
+
+	// exchange settings of devices, nil means defaults
+	profiles *push.ProfileHook
+}
 
 func (h hook) OnInitialExchange(d push.Device) *push.ExchangeCommand {
 	log.Println("SN:", d.SN)
@@ -18,20 +30,73 @@ func (h hook) OnInitialExchange(d push.Device) *push.ExchangeCommand {
 	log.Println("lang:", push.LangText(d.Language))
 	log.Println("push ver:", d.PushVersion)
 
+	if h.profiles != nil {
+		return h.profiles.OnInitialExchange(d)
+	}
+
+	// upload stamps are resumed from stamp store
+	// time zone sent to device derived from location
 	return &push.ExchangeCommand{
-		SN:          d.SN,
-		AttLogStamp: 9999,
-		Delay:       10,
-		TimeZone:    7,
+		SN:       d.SN,
+		Delay:    10,
+		Location: "Asia/Jakarta",
 	}
 }
 
+func (h hook) OnDeviceOnline(d push.DeviceStatus) {
+	log.Printf("device %s online from %s\n", d.SN, d.IP)
+}
+
+func (h hook) OnDeviceOffline(d push.DeviceStatus) {
+	log.Printf("device %s offline, last seen %s\n", d.SN, d.LastSeen)
+}
+
+func (h hook) OnDeviceInfo(sn string, info push.DeviceInfo) {
+	log.Printf("device %s firmware: %s, users: %d, att logs: %d\n", sn, info.FirmwareVersion, info.UserCount, info.AttLogCount)
+}
+
+func (h hook) OnAttendanceLog(sn string, l push.AttendanceLog) {
+	log.Printf("device %s attendance: %s at %s\n", sn, l.PIN, l.Time)
+}
+
+func (h hook) OnOperationLog(sn string, l push.OperationLog) {
+	log.Printf("device %s operation %d by %s\n", sn, l.Type, l.Operator)
+}
+
+func (h hook) OnAccessEvent(sn string, e push.AccessEvent) {
+	log.Printf("device %s door %d event %d: %s at %s\n", sn, e.Door, e.Event, e.PIN, e.Time)
+}
+
+func (h hook) OnDoorState(sn string, d push.DoorState) {
+	log.Printf("device %s door state sensor=%x relay=%x alarm=%x\n", sn, d.Sensor, d.Relay, d.Alarm)
+}
+
 func (h hook) Middlewares(option *push.ServerOption) []push.Middleware {
 	return []push.Middleware{
 		push.MiddlewareFunc(Verbose),
 	}
 }
 
+func (h hook) AdminMiddlewares(option *push.ServerOption) []push.Middleware {
+	return []push.Middleware{
+		push.MiddlewareFunc(Verbose),
+		push.MiddlewareFunc(h.RequireToken),
+	}
+}
+
+// RequireToken reject management request without valid bearer token
+func (h hook) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func Verbose(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[%s] %s\n", r.Method, r.URL)
@@ -42,43 +107,124 @@ func Verbose(next http.Handler) http.Handler {
 
 func main() {
 	var (
-		host     string
-		addr     string
-		certFile string
-		keyFile  string
+		host      string
+		addr      string
+		certFile  string
+		keyFile   string
+		stampFile string
+		devices   string
+		admin     string
+		token     string
+		hookURL   string
+		hookKey   string
+		redisHost string
+		schedules string
+		publicURL string
+		profiles  string
 	)
 
 	flag.StringVar(&host, "host", "192.168.1.177", "host name")
 	flag.StringVar(&addr, "address", ":8081", "http server address")
 	flag.StringVar(&certFile, "cert-file", "cert.pem", "TLS cert file")
 	flag.StringVar(&keyFile, "key-file", "key.pem", "TLS key file")
+	flag.StringVar(&stampFile, "stamp-file", "stamps.json", "upload stamps file")
+	flag.StringVar(&devices, "devices", "BRM9181260009", "comma separated serial number of allowed devices")
+	flag.StringVar(&admin, "admin-prefix", "/admin", "management api path prefix, empty to disable")
+	flag.StringVar(&token, "admin-token", os.Getenv("PUSH_ADMIN_TOKEN"), "management api bearer token")
+
+	flag.StringVar(&hookURL, "webhook-url", "", "forward device events to webhook url")
+	flag.StringVar(&hookKey, "webhook-secret", os.Getenv("PUSH_WEBHOOK_SECRET"), "webhook signature secret")
+
+	flag.StringVar(&redisHost, "redis", "", "redis address of state shared by replicas, empty to keep in memory")
+
+	flag.StringVar(&schedules, "schedule-file", "schedules.json", "scheduled commands file, unused when state shared on redis")
+
+	flag.StringVar(&publicURL, "public-url", "", "server url reachable by devices, required by file transfer")
+
+	flag.StringVar(&profiles, "profile-file", "", "device profiles file, reloaded when modified")
 
 	flag.Parse()
 
+	stamps, err := push.NewFileStampStore(stampFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	option := &push.ServerOption{
-		Name:     host,
-		Address:  addr,
-		CertFile: certFile,
-		KeyFile:  keyFile,
+		Name:        host,
+		Address:     addr,
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		StampStore:  stamps,
+		AdminPrefix: admin,
+		PublicURL:   publicURL,
 	}
-	s := push.NewServer(option, &hook{})
 
-	// explicitly register device
-	s.RegisterDevice("BRM9181260009")
+	// schedules managed through management api, run
+	// by single replica when state shared
+	if redisHost != "" {
+		option.SharedState = push.NewRedisSharedState(&queue.RedisOption{Host: redisHost})
+		option.ScheduleStore = push.NewSharedScheduleStore(option.SharedState)
+	} else if option.ScheduleStore, err = push.NewFileScheduleStore(schedules); err != nil {
+		log.Fatal(err)
+	}
 
-	go s.Start(context.Background())
+	h := &hook{adminToken: token}
+	if profiles != "" {
+		if h.profiles, err = push.LoadProfileHook(profiles); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	<-s.Ready()
+	s := push.NewServer(option, h)
+	if h.profiles != nil {
+		h.profiles.Registry = s.Registry()
+	}
 
-	resp, err := s.Do("BRM9181260009", push.Command{CMD: "REBOOT"})
-	if err != nil {
-		log.Println(err)
+	// explicitly register devices
+	for _, sn := range strings.Split(devices, ",") {
+		if sn = strings.TrimSpace(sn); sn != "" {
+			s.RegisterDevice(sn)
+		}
 	}
 
-	if resp.IsOK() {
-		log.Println("Send reboot command successful")
+	// shutdown gracefully on interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		cancel()
+	}()
+
+	if hookURL != "" {
+		forwarder := webhook.New(&webhook.Option{
+			Endpoints: []webhook.Endpoint{{URL: hookURL, Secret: hookKey}},
+		})
+
+		go forwarder.Run(ctx)
+		go forwarder.ForwardPush(ctx, s.Events(), push.EventFilter{})
 	}
 
-	fmt.Scanln()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Start(ctx)
+	}()
 
+	// bind error returned before ready
+	select {
+	case <-s.Ready():
+	case err := <-errc:
+		log.Fatal(err)
+	}
+
+	// commands issued through management api, e.g.
+	// curl -H "Authorization: Bearer $PUSH_ADMIN_TOKEN" \
+	//   -d '{"cmd": "REBOOT", "priority": 2}' \
+	//   https://host:8081/admin/devices/BRM9181260009/commands
+	if err := <-errc; err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,306 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// access control related error
+var (
+	ErrInvalidDoor     = errors.New("Door number must be between 1 and 255")
+	ErrInvalidDuration = errors.New("Door open duration must be between 0 and 254 seconds")
+)
+
+// event types of access control panel
+const (
+	EventAccess    = "access"
+	EventDoorState = "door_state"
+)
+
+// operation of CONTROL DEVICE command
+const (
+	controlOutput      = 0x01
+	controlCancelAlarm = 0x02
+	controlNormalOpen  = 0x04
+)
+
+// output duration which keep door open until closed
+const doorNormalOpen = 0xFF
+
+// AccessEventHook define callback upon receiving realtime
+// events and door states uploaded by access control panel
+type AccessEventHook interface {
+	OnAccessEvent(sn string, event AccessEvent)
+	OnDoorState(sn string, state DoorState)
+}
+
+// AccessEvent represent single realtime event of rtlog table,
+// e.g. verification on reader or door alarm
+type AccessEvent struct {
+	Time time.Time `json:"time"`
+	PIN  string    `json:"pin,omitempty"`
+	Card string    `json:"card,omitempty"`
+
+	// door or auxiliary input which raise event
+	Door int `json:"door"`
+
+	// event code, alarm event compared with alarm
+	// reason, e.g. DoorOpenDetected
+	Event int `json:"event"`
+
+	// entry / exit direction
+	InOut int `json:"in_out"`
+
+	// verification mode, e.g. finger print, card
+	Verify int `json:"verify"`
+
+	// index of record on device
+	Index int `json:"index"`
+}
+
+// Unmarshall implement payload.Unmarshall interface,
+// time parsed on server local time
+func (e *AccessEvent) Unmarshall(b []byte) error {
+	return e.unmarshallIn(b, time.Local)
+}
+
+// unmarshallIn decode event which time written on given location
+func (e *AccessEvent) unmarshallIn(b []byte, loc *time.Location) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(b, "\t")
+
+	t, err := parseDeviceTime(logTimeLayout, fields["time"], loc)
+	if err != nil {
+		return ErrInvalidLogRecord
+	}
+
+	e.Time = t
+	e.PIN = fields["pin"]
+	e.Card = fields["cardno"]
+	e.Door = value(fields["eventaddr"]).ToInt(0)
+	e.Event = value(fields["event"]).ToInt(0)
+	e.InOut = value(fields["inoutstatus"]).ToInt(0)
+	e.Verify = value(fields["verifytype"]).ToInt(0)
+	e.Index = value(fields["index"]).ToInt(0)
+
+	// card number zero means no card presented
+	if e.Card == "0" {
+		e.Card = ""
+	}
+
+	// pin zero means no user identified
+	if e.PIN == "0" {
+		e.PIN = ""
+	}
+
+	return nil
+}
+
+// IsAlarm check whether event is alarm of door or device
+func (e AccessEvent) IsAlarm() bool {
+	switch e.Event {
+	case DoorOpenDetected, DoorBrokenAccidentally, MachineBeenBroken, TryInvalidVerfication:
+		return true
+	}
+
+	return false
+}
+
+// DoorState represent states of doors, relays and alarms of rtstate
+// table. sensor, relay and door states are bit masks, where bit 0
+// is door or relay 1
+type DoorState struct {
+	Time time.Time `json:"time"`
+
+	// door sensor of opened doors
+	Sensor uint64 `json:"sensor"`
+
+	// activated relays
+	Relay uint64 `json:"relay"`
+
+	// alarms, one byte of alarm flags per door
+	Alarm uint64 `json:"alarm"`
+
+	// doors in normal open state
+	Door uint64 `json:"door"`
+}
+
+// Unmarshall implement payload.Unmarshall interface,
+// time parsed on server local time
+func (d *DoorState) Unmarshall(b []byte) error {
+	return d.unmarshallIn(b, time.Local)
+}
+
+// unmarshallIn decode state which time written on given location
+func (d *DoorState) unmarshallIn(b []byte, loc *time.Location) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(b, "\t")
+
+	t, err := parseDeviceTime(logTimeLayout, fields["time"], loc)
+	if err != nil {
+		return ErrInvalidLogRecord
+	}
+
+	d.Time = t
+	d.Sensor = hexMask(fields["sensor"])
+	d.Relay = hexMask(fields["relay"])
+	d.Alarm = hexMask(fields["alarm"])
+	d.Door = hexMask(fields["door"])
+
+	return nil
+}
+
+// DoorOpen check whether sensor of door detect door opened
+func (d DoorState) DoorOpen(door int) bool {
+	return maskBit(d.Sensor, door)
+}
+
+// RelayActive check whether relay is activated
+func (d DoorState) RelayActive(relay int) bool {
+	return maskBit(d.Relay, relay)
+}
+
+// AlarmOf return alarm flags of door, zero means no alarm
+func (d DoorState) AlarmOf(door int) int {
+	if door < 1 || door > 8 {
+		return 0
+	}
+
+	return int(d.Alarm>>(uint(door-1)*8)) & 0xFF
+}
+
+// hexMask parse hex bit mask, zero when invalid
+func hexMask(s string) uint64 {
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0
+	}
+
+	return v
+}
+
+// maskBit check bit of door or relay, starting from 1
+func maskBit(mask uint64, n int) bool {
+	if n < 1 || n > 64 {
+		return false
+	}
+
+	return mask&(1<<uint(n-1)) != 0
+}
+
+// controlCommand create CONTROL DEVICE command
+// which payload consist of five hex bytes
+func controlCommand(operation, target, kind, duration int) Command {
+	return Command{
+		CMD:     "CONTROL DEVICE",
+		Payload: []byte(fmt.Sprintf("%02X%02X%02X%02X00", operation, target, kind, duration)),
+	}
+}
+
+// OpenDoorCommand create command which open door for given seconds,
+// zero seconds keep door open until CloseDoorCommand
+func OpenDoorCommand(door int, seconds int) (Command, error) {
+	if door < 1 || door > 255 {
+		return Command{}, ErrInvalidDoor
+	}
+
+	if seconds < 0 || seconds > 254 {
+		return Command{}, ErrInvalidDuration
+	}
+
+	if seconds == 0 {
+		seconds = doorNormalOpen
+	}
+
+	return controlCommand(controlOutput, door, 1, seconds), nil
+}
+
+// CloseDoorCommand create command which close opened door
+func CloseDoorCommand(door int) (Command, error) {
+	if door < 1 || door > 255 {
+		return Command{}, ErrInvalidDoor
+	}
+
+	return controlCommand(controlOutput, door, 1, 0), nil
+}
+
+// LockDoorCommand create command which disable normal open
+// state of door, door stay locked until next verification
+func LockDoorCommand(door int) (Command, error) {
+	if door < 1 || door > 255 {
+		return Command{}, ErrInvalidDoor
+	}
+
+	return controlCommand(controlNormalOpen, door, 0, 0), nil
+}
+
+// CancelAlarmCommand create command which cancel alarms of panel
+func CancelAlarmCommand() Command {
+	return controlCommand(controlCancelAlarm, 0, 0, 0)
+}
+
+func (s *Server) uploadAccessEvents(sn string, body []byte) error {
+	loc := s.location(sn)
+
+	for _, line := range bytes.Split(body, lf) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var event AccessEvent
+		if err := event.unmarshallIn(line, loc); err != nil {
+			log.Printf("skipped invalid access event of %s: %v\n", sn, err)
+			continue
+		}
+
+		// call hook
+		if s.hook != nil {
+			if hook, ok := s.hook.(AccessEventHook); ok {
+				hook.OnAccessEvent(sn, event)
+			}
+		}
+
+		s.publish(EventAccess, sn, event)
+	}
+
+	return nil
+}
+
+func (s *Server) uploadDoorStates(sn string, body []byte) error {
+	loc := s.location(sn)
+
+	for _, line := range bytes.Split(body, lf) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var state DoorState
+		if err := state.unmarshallIn(line, loc); err != nil {
+			log.Printf("skipped invalid door state of %s: %v\n", sn, err)
+			continue
+		}
+
+		// call hook
+		if s.hook != nil {
+			if hook, ok := s.hook.(AccessEventHook); ok {
+				hook.OnDoorState(sn, state)
+			}
+		}
+
+		s.publish(EventDoorState, sn, state)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package push
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessEventUnmarshall(t *testing.T) {
+	var e AccessEvent
+	if err := Unmarshall([]byte("time=2019-05-20 08:00:00\tpin=12\tcardno=0\teventaddr=2\tevent=0\tinoutstatus=1\tverifytype=1\tindex=34"), &e); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	expected := AccessEvent{
+		Time:   time.Date(2019, 5, 20, 8, 0, 0, 0, time.Local),
+		PIN:    "12",
+		Door:   2,
+		InOut:  1,
+		Verify: 1,
+		Index:  34,
+	}
+
+	if e != expected {
+		t.Errorf("expected %+v but returned %+v", expected, e)
+	}
+
+	if err := Unmarshall([]byte("time=2019-05-20 08:01:00\tpin=0\tcardno=0\teventaddr=1\tevent=54"), &e); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if !e.IsAlarm() || e.PIN != "" {
+		t.Errorf("expected door alarm but returned %+v", e)
+	}
+}
+
+func TestDoorStateUnmarshall(t *testing.T) {
+	var d DoorState
+	if err := Unmarshall([]byte("time=2019-05-20 08:00:00\tsensor=02\trelay=01\talarm=0000000000000200\tdoor=00"), &d); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if d.DoorOpen(1) || !d.DoorOpen(2) {
+		t.Errorf("expected only door 2 opened but returned %x", d.Sensor)
+	}
+
+	if !d.RelayActive(1) || d.RelayActive(2) {
+		t.Errorf("expected only relay 1 active but returned %x", d.Relay)
+	}
+
+	if d.AlarmOf(1) != 0 || d.AlarmOf(2) != 2 {
+		t.Errorf("unexpected alarm %x", d.Alarm)
+	}
+}
+
+func TestDoorCommands(t *testing.T) {
+	cases := []struct {
+		cmd     func() (Command, error)
+		payload string
+	}{
+		{func() (Command, error) { return OpenDoorCommand(1, 5) }, "0101010500"},
+		{func() (Command, error) { return OpenDoorCommand(2, 0) }, "010201FF00"},
+		{func() (Command, error) { return CloseDoorCommand(1) }, "0101010000"},
+		{func() (Command, error) { return LockDoorCommand(3) }, "0403000000"},
+		{func() (Command, error) { return CancelAlarmCommand(), nil }, "0200000000"},
+	}
+
+	for _, c := range cases {
+		cmd, err := c.cmd()
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if cmd.CMD != "CONTROL DEVICE" || string(cmd.Payload) != c.payload {
+			t.Errorf("expected payload %s but returned %s %s", c.payload, cmd.CMD, cmd.Payload)
+		}
+	}
+
+	if _, err := OpenDoorCommand(0, 5); err != ErrInvalidDoor {
+		t.Errorf("expected invalid door but returned %v", err)
+	}
+
+	if _, err := OpenDoorCommand(1, 300); err != ErrInvalidDuration {
+		t.Errorf("expected invalid duration but returned %v", err)
+	}
+}
+
+type accessHook struct {
+	events []AccessEvent
+	states []DoorState
+}
+
+func (h *accessHook) OnAccessEvent(sn string, event AccessEvent) {
+	h.events = append(h.events, event)
+}
+
+func (h *accessHook) OnDoorState(sn string, state DoorState) {
+	h.states = append(h.states, state)
+}
+
+func TestUploadAccessTables(t *testing.T) {
+	hook := &accessHook{}
+	s := NewServer(&ServerOption{}, hook)
+	s.RegisterDevice("123456789")
+
+	body := "time=2019-05-20 08:00:00\tpin=12\tcardno=0\teventaddr=1\tevent=0\tinoutstatus=0\tverifytype=1\tindex=1\n" +
+		"time=2019-05-20 08:00:05\tpin=0\tcardno=0\teventaddr=1\tevent=51\tinoutstatus=0\tverifytype=0\tindex=2\n"
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=rtlog", strings.NewReader(body)))
+	if w.Body.String() != "OK" {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=rtstate", strings.NewReader("time=2019-05-20 08:00:05\tsensor=01\trelay=00\talarm=0000000000000000\tdoor=00")))
+	if w.Body.String() != "OK" {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+
+	if len(hook.events) != 2 || hook.events[1].Event != DoorOpenDetected {
+		t.Errorf("unexpected access events %+v", hook.events)
+	}
+
+	if len(hook.states) != 1 || !hook.states[0].DoorOpen(1) {
+		t.Errorf("unexpected door states %+v", hook.states)
+	}
+
+	if status, _ := s.registry.Get("123456789"); !status.LastUpload.IsZero() {
+		t.Error("expected realtime event not recorded as upload")
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader("12\t2019-05-20 08:00:00\t0\t1\n")))
+
+	if status, _ := s.registry.Get("123456789"); status.LastUpload.IsZero() {
+		t.Error("expected attendance log recorded as upload")
+	}
+}
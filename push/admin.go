@@ -0,0 +1,788 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// management api error
+var (
+	ErrEmptyCommand    = errors.New("Command is empty")
+	ErrInvalidCommand  = errors.New("Command must not contain line break, nor tab on its name")
+	ErrCommandNotFound = errors.New("Command not found")
+
+	ErrStreamingNotSupported = errors.New("Streaming not supported")
+
+	ErrAdminUnprotected = errors.New("Management api requires admin middlewares")
+)
+
+// interval of keep alive comment on idle event stream
+var eventKeepAliveInterval = time.Second * 15
+
+// default waiting time of remote finger print enrollment
+var defaultEnrollTimeout = time.Minute
+
+// default waiting time of reading or writing device options
+var defaultOptionTimeout = time.Minute
+
+// default waiting time of file transfer, including firmware upgrade
+var defaultFileTimeout = time.Minute * 10
+
+// AdminMiddlewareProvider define middleware pipelines of
+// management api, e.g. authentication of operator.
+// protocol middlewares from MiddlewareProvider are not applied
+type AdminMiddlewareProvider interface {
+	AdminMiddlewares(*ServerOption) []Middleware
+}
+
+// adminDevice is json representation of device status,
+// comm key is never exposed
+type adminDevice struct {
+	SN              string    `json:"sn"`
+	PushVersion     string    `json:"push_version,omitempty"`
+	Language        int       `json:"language,omitempty"`
+	IP              string    `json:"ip,omitempty"`
+	Delay           int       `json:"delay"`
+	LastSeen        time.Time `json:"last_seen"`
+	Online          bool      `json:"online"`
+	Encrypted       bool      `json:"encrypted"`
+	FirmwareVersion string    `json:"firmware_version,omitempty"`
+	Groups          []string  `json:"groups"`
+	Pending         int       `json:"pending"`
+
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// adminQueuedCommand is json representation of pending command
+type adminQueuedCommand struct {
+	ID       string          `json:"id"`
+	CMD      string          `json:"cmd"`
+	Payload  string          `json:"payload,omitempty"`
+	Priority CommandPriority `json:"priority"`
+	ExpireAt time.Time       `json:"expire_at,omitempty"`
+}
+
+// adminCommandRequest is body of enqueue command request
+type adminCommandRequest struct {
+	CMD      string          `json:"cmd"`
+	Payload  string          `json:"payload"`
+	Priority CommandPriority `json:"priority"`
+
+	// command expired when not delivered within ttl,
+	// zero means server default
+	TTLSeconds int `json:"ttl_seconds"`
+
+	// re-delivery until acknowledged, zero means server default
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// validRawCommand check command given as text, which must fit
+// single line. separator would inject another command, which
+// skip capability check and command history
+func validRawCommand(cmd string, payload string) error {
+	if cmd == "" {
+		return ErrEmptyCommand
+	}
+
+	if strings.ContainsAny(cmd, "\t\r\n") || strings.ContainsAny(payload, "\r\n") {
+		return ErrInvalidCommand
+	}
+
+	return nil
+}
+
+// command create command from request
+func (r adminCommandRequest) command() (Command, error) {
+	if err := validRawCommand(r.CMD, r.Payload); err != nil {
+		return Command{}, err
+	}
+
+	cmd := Command{
+		ID:       randomCommandID(),
+		CMD:      r.CMD,
+		Payload:  []byte(r.Payload),
+		Priority: r.Priority,
+	}
+
+	if r.TTLSeconds > 0 {
+		cmd.ExpireAt = time.Now().Add(time.Duration(r.TTLSeconds) * time.Second)
+	}
+
+	if r.MaxAttempts > 0 {
+		cmd.Retry = &RetryPolicy{MaxAttempts: r.MaxAttempts}
+	}
+
+	return cmd, nil
+}
+
+// adminGroupsRequest is body of set device groups request
+type adminGroupsRequest struct {
+	Groups []string `json:"groups"`
+}
+
+// adminEnrollRequest is body of finger print enrollment request
+type adminEnrollRequest struct {
+	PIN     string `json:"pin"`
+	FID     int    `json:"fid"`
+	Retries int    `json:"retries"`
+
+	// maximum waiting time of enrollment, default 60 seconds
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// adminOptionsRequest is body of set device options request
+type adminOptionsRequest struct {
+	Options map[string]string `json:"options"`
+
+	// maximum waiting time of device, default 60 seconds
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// adminGroupResult is enqueue result of single group member,
+// either command record or error
+type adminGroupResult struct {
+	SN      string         `json:"sn"`
+	Command *CommandRecord `json:"command,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// adminError is json body of failed request
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON write v as json response with given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAdminError write error as json response,
+// unknown device reported as not found
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	if err == ErrDeviceNotRegistered {
+		status = http.StatusNotFound
+	}
+
+	writeJSON(w, status, adminError{Error: err.Error()})
+}
+
+// adminDevice return json representation of device status
+func (s *Server) adminDevice(status DeviceStatus) adminDevice {
+	d := adminDevice{
+		SN:              status.SN,
+		PushVersion:     status.PushVersion,
+		Language:        status.Language,
+		IP:              status.IP,
+		Delay:           status.Delay,
+		LastSeen:        status.LastSeen,
+		Online:          status.Online,
+		Encrypted:       status.Encrypted,
+		FirmwareVersion: status.Info.FirmwareVersion,
+		Groups:          status.Groups,
+		Capabilities:    status.Capabilities,
+	}
+
+	if d.Groups == nil {
+		d.Groups = []string{}
+	}
+
+	if cmds, err := s.getCommandQueue(status.SN); err == nil {
+		d.Pending = len(cmds)
+	}
+
+	return d
+}
+
+// enqueueCommand put command requested by operator into device queue
+func (s *Server) enqueueCommand(sn string, req adminCommandRequest) (CommandRecord, error) {
+	cmd, err := req.command()
+	if err != nil {
+		return CommandRecord{}, err
+	}
+
+	if err := s.putCommandQueue(sn, cmd); err != nil {
+		return CommandRecord{}, err
+	}
+
+	record, _ := s.history.Get(cmd.ID)
+
+	return record, nil
+}
+
+// skipCommand record command requested by operator as skipped
+func (s *Server) skipCommand(sn string, req adminCommandRequest, reason error) CommandRecord {
+	now := time.Now()
+	record := CommandRecord{
+		ID:          randomCommandID(),
+		SN:          sn,
+		CMD:         req.CMD,
+		Payload:     req.Payload,
+		Priority:    req.Priority,
+		Status:      CommandSkipped,
+		Error:       reason.Error(),
+		QueuedAt:    now,
+		CompletedAt: now,
+	}
+	s.saveCommandRecord(record)
+
+	return record
+}
+
+func (s *Server) handleAdminDevices(w http.ResponseWriter, r *http.Request) {
+	var statuses []DeviceStatus
+	if group := r.URL.Query().Get("group"); group != "" {
+		statuses = s.registry.Members(group)
+	} else {
+		statuses = s.registry.List()
+	}
+
+	devices := make([]adminDevice, 0, len(statuses))
+	for _, status := range statuses {
+		devices = append(devices, s.adminDevice(status))
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) handleAdminDevice(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.registry.Get(mux.Vars(r)["sn"])
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, ErrDeviceNotRegistered)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.adminDevice(status))
+}
+
+func (s *Server) handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	cmds, err := s.getCommandQueue(mux.Vars(r)["sn"])
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	queue := make([]adminQueuedCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		queue = append(queue, adminQueuedCommand{
+			ID:       cmd.ID,
+			CMD:      cmd.CMD,
+			Payload:  string(cmd.Payload),
+			Priority: cmd.Priority,
+			ExpireAt: cmd.ExpireAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, queue)
+}
+
+func (s *Server) handleAdminEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req adminCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := s.enqueueCommand(mux.Vars(r)["sn"], req)
+	if err == ErrEmptyCommand || err == ErrInvalidCommand {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err == ErrCapabilityNotSupported {
+		writeAdminError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, record)
+}
+
+func (s *Server) handleAdminGroupEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req adminCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validRawCommand(req.CMD, req.Payload); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// enqueue on every member, failure of one
+	// member doesn't stop the others
+	status := http.StatusAccepted
+	results := make([]adminGroupResult, 0)
+	for _, member := range s.registry.Members(mux.Vars(r)["group"]) {
+		result := adminGroupResult{SN: member.SN}
+
+		record, err := s.enqueueCommand(member.SN, req)
+		if err == ErrCapabilityNotSupported {
+			// group may mix models, skip those lacking function
+			record = s.skipCommand(member.SN, req, err)
+			err = nil
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			status = http.StatusMultiStatus
+		} else {
+			result.Command = &record
+		}
+
+		results = append(results, result)
+	}
+
+	writeJSON(w, status, results)
+}
+
+func (s *Server) handleAdminDeviceCommands(w http.ResponseWriter, r *http.Request) {
+	sn := mux.Vars(r)["sn"]
+	if _, ok := s.registry.Get(sn); !ok {
+		writeAdminError(w, http.StatusNotFound, ErrDeviceNotRegistered)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	writeJSON(w, http.StatusOK, s.history.List(sn, limit))
+}
+
+func (s *Server) handleAdminCommand(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.history.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, ErrCommandNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (s *Server) handleAdminGroups(w http.ResponseWriter, r *http.Request) {
+	var req adminGroupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sn := mux.Vars(r)["sn"]
+	if !s.registry.SetGroups(sn, req.Groups) {
+		writeAdminError(w, http.StatusNotFound, ErrDeviceNotRegistered)
+		return
+	}
+
+	status, _ := s.registry.Get(sn)
+	writeJSON(w, http.StatusOK, s.adminDevice(status))
+}
+
+// handleAdminEnroll start finger print enrollment and
+// respond enrolled template once uploaded by device
+func (s *Server) handleAdminEnroll(w http.ResponseWriter, r *http.Request) {
+	var req adminEnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	timeout := defaultEnrollTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	fp, err := s.EnrollFingerprint(ctx, mux.Vars(r)["sn"], req.PIN, req.FID, req.Retries)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, fp)
+	case ErrInvalidFingerIndex, ErrInvalidPIN:
+		writeAdminError(w, http.StatusBadRequest, err)
+	case context.DeadlineExceeded:
+		writeAdminError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeAdminError(w, http.StatusUnprocessableEntity, err)
+	}
+}
+
+// writeOptionError write error of reading or writing device options
+func writeOptionError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrInvalidOptionKey, ErrInvalidOptionValue:
+		writeAdminError(w, http.StatusBadRequest, err)
+	case context.DeadlineExceeded:
+		writeAdminError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeAdminError(w, http.StatusUnprocessableEntity, err)
+	}
+}
+
+// handleAdminOptions read device options, either
+// those given by key query parameter or all
+func (s *Server) handleAdminOptions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), defaultOptionTimeout)
+	defer cancel()
+
+	options, err := s.GetOptions(ctx, mux.Vars(r)["sn"], queryList(r.URL.Query(), "key")...)
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, options)
+}
+
+// handleAdminSetOptions write device options
+// and respond changed values
+func (s *Server) handleAdminSetOptions(w http.ResponseWriter, r *http.Request) {
+	var req adminOptionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	timeout := defaultOptionTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	changes, err := s.SetOptions(ctx, mux.Vars(r)["sn"], req.Options)
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// writeFileError write error of file transfer
+func writeFileError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrEmptyFilePath, ErrInvalidFilePath, ErrEmptyPayload:
+		writeAdminError(w, http.StatusBadRequest, err)
+	case ErrPublicURLRequired:
+		writeAdminError(w, http.StatusNotImplemented, err)
+	case context.DeadlineExceeded:
+		writeAdminError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeAdminError(w, http.StatusUnprocessableEntity, err)
+	}
+}
+
+// handleAdminGetFile respond content of file
+// uploaded by device, given by path query parameter
+func (s *Server) handleAdminGetFile(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), defaultFileTimeout)
+	defer cancel()
+
+	content, err := s.GetFile(ctx, mux.Vars(r)["sn"], r.URL.Query().Get("path"))
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// handleAdminPutFile send request body to device,
+// saved on path given by query parameter
+func (s *Server) handleAdminPutFile(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), defaultFileTimeout)
+	defer cancel()
+
+	if err := s.PutFile(ctx, mux.Vars(r)["sn"], r.URL.Query().Get("path"), content); err != nil {
+		writeFileError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminUpgrade send firmware in request body to device
+func (s *Server) handleAdminUpgrade(w http.ResponseWriter, r *http.Request) {
+	firmware, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), defaultFileTimeout)
+	defer cancel()
+
+	if err := s.UpgradeFirmware(ctx, mux.Vars(r)["sn"], firmware); err != nil {
+		writeFileError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminScheduler return scheduler, writing error when disabled
+func (s *Server) adminScheduler(w http.ResponseWriter) (*Scheduler, bool) {
+	if s.scheduler == nil {
+		writeAdminError(w, http.StatusNotImplemented, ErrSchedulerDisabled)
+		return nil, false
+	}
+
+	return s.scheduler, true
+}
+
+func (s *Server) handleAdminSchedules(w http.ResponseWriter, r *http.Request) {
+	scheduler, ok := s.adminScheduler(w)
+	if !ok {
+		return
+	}
+
+	schedules, err := scheduler.List()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// handleAdminSaveSchedule add schedule, or replace
+// schedule given by id path parameter
+func (s *Server) handleAdminSaveSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduler, ok := s.adminScheduler(w)
+	if !ok {
+		return
+	}
+
+	var schedule Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if id := mux.Vars(r)["id"]; id != "" {
+		schedule.ID = id
+		status = http.StatusOK
+	}
+
+	schedule, err := scheduler.Add(schedule)
+	if err == ErrEmptyCommand || err == ErrInvalidCommand || err == ErrInvalidCronSpec {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, status, schedule)
+}
+
+func (s *Server) handleAdminRemoveSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduler, ok := s.adminScheduler(w)
+	if !ok {
+		return
+	}
+
+	err := scheduler.Remove(mux.Vars(r)["id"])
+	if err == ErrScheduleNotFound {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// queryList return values of query parameter, either
+// repeated or comma separated
+func queryList(query url.Values, key string) []string {
+	var list []string
+	for _, v := range query[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// handleAdminEvents stream device events as server sent events,
+// filtered by sn and type query parameter
+func (s *Server) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAdminError(w, http.StatusInternalServerError, ErrStreamingNotSupported)
+		return
+	}
+
+	query := r.URL.Query()
+	sub := s.events.Subscribe(EventFilter{
+		SN:    queryList(query, "sn"),
+		Types: queryList(query, "type"),
+	}, 0)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	stop := s.stopping()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stop:
+			return
+		case <-keepAlive.C:
+			// comment line, keep idle connection open through proxies
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("failed to encode event %s: %v\n", e.Type, err)
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+		}
+
+		flusher.Flush()
+	}
+}
+
+// adminMiddlewares return middlewares of management api
+func (s *Server) adminMiddlewares() []Middleware {
+	var mws = make([]Middleware, 0)
+	if s.hook != nil {
+		if hook, ok := s.hook.(AdminMiddlewareProvider); ok {
+			mws = append(mws, hook.AdminMiddlewares(s.option)...)
+		}
+	}
+
+	return mws
+}
+
+// registerAdminAPI register management endpoints
+func (s *Server) registerAdminAPI(router *mux.Router) {
+	mws := s.adminMiddlewares()
+
+	admin := func(h http.HandlerFunc) http.Handler {
+		return DecorateHandler(h, mws...)
+	}
+
+	router.Handle("/devices", admin(s.handleAdminDevices)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}", admin(s.handleAdminDevice)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}/queue", admin(s.handleAdminQueue)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}/commands", admin(s.handleAdminDeviceCommands)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}/commands", admin(s.handleAdminEnqueue)).
+		Methods("POST")
+
+	router.Handle("/devices/{sn}/groups", admin(s.handleAdminGroups)).
+		Methods("PUT")
+
+	router.Handle("/devices/{sn}/fingerprints", admin(s.handleAdminEnroll)).
+		Methods("POST")
+
+	router.Handle("/devices/{sn}/options", admin(s.handleAdminOptions)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}/options", admin(s.handleAdminSetOptions)).
+		Methods("PUT")
+
+	router.Handle("/devices/{sn}/files", admin(s.handleAdminGetFile)).
+		Methods("GET")
+
+	router.Handle("/devices/{sn}/files", admin(s.handleAdminPutFile)).
+		Methods("PUT")
+
+	router.Handle("/devices/{sn}/firmware", admin(s.handleAdminUpgrade)).
+		Methods("PUT")
+
+	router.Handle("/groups/{group}/commands", admin(s.handleAdminGroupEnqueue)).
+		Methods("POST")
+
+	router.Handle("/commands/{id}", admin(s.handleAdminCommand)).
+		Methods("GET")
+
+	router.Handle("/schedules", admin(s.handleAdminSchedules)).
+		Methods("GET")
+
+	router.Handle("/schedules", admin(s.handleAdminSaveSchedule)).
+		Methods("POST")
+
+	router.Handle("/schedules/{id}", admin(s.handleAdminSaveSchedule)).
+		Methods("PUT")
+
+	router.Handle("/schedules/{id}", admin(s.handleAdminRemoveSchedule)).
+		Methods("DELETE")
+
+	router.Handle("/events", admin(s.handleAdminEvents)).
+		Methods("GET")
+}
+
+// AdminHandler return http handler which serve management api,
+// decorated with middlewares from AdminMiddlewareProvider.
+// served by Handler under AdminPrefix when configured, or
+// can be mounted separately, e.g. on internal only listener,
+// in which case caller is responsible to protect it
+func (s *Server) AdminHandler() http.Handler {
+	s.adminOnce.Do(func() {
+		router := mux.NewRouter()
+		s.registerAdminAPI(router)
+		s.admin = router
+	})
+
+	return s.admin
+}
@@ -0,0 +1,198 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminHook protect management api by pass through middleware
+type adminHook struct{}
+
+func (h adminHook) AdminMiddlewares(option *ServerOption) []Middleware {
+	return []Middleware{MiddlewareFunc(func(next http.Handler) http.Handler {
+		return next
+	})}
+}
+
+func TestAdminUnprotected(t *testing.T) {
+	s := NewServer(&ServerOption{AdminPrefix: "/admin"})
+	s.RegisterDevice("123456789")
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/admin/devices", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 but returned %d", w.Code)
+	}
+}
+
+func TestAdminEnqueueCommand(t *testing.T) {
+	s := NewServer(&ServerOption{AdminPrefix: "/admin"}, adminHook{})
+	s.RegisterDevice("123456789")
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	body := `{"cmd": "REBOOT", "priority": 2}`
+	resp, err := http.Post(srv.URL+"/admin/devices/123456789/commands", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	var record CommandRecord
+	json.NewDecoder(resp.Body).Decode(&record)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted || record.ID == "" || record.Status != CommandQueued {
+		t.Errorf("expected queued command but returned %d %+v", resp.StatusCode, record)
+		t.FailNow()
+	}
+
+	// device fetch and respond command
+	resp, err = http.Get(srv.URL + "/iclock/getrequest?SN=123456789")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	result := "ID=" + record.ID + "&Return=0&CMD=REBOOT"
+	resp, err = http.Post(srv.URL+"/iclock/devicecmd?SN=123456789", "text/plain", strings.NewReader(result))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(srv.URL + "/admin/commands/" + record.ID)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	json.NewDecoder(resp.Body).Decode(&record)
+	resp.Body.Close()
+
+	if record.Status != CommandSucceeded || record.Attempts != 1 {
+		t.Errorf("expected succeeded command but returned %+v", record)
+		t.FailNow()
+	}
+
+	// unknown device
+	resp, err = http.Post(srv.URL+"/admin/devices/987654321/commands", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 but returned %d", resp.StatusCode)
+	}
+}
+
+func TestAdminGroupCommand(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("1")
+	s.RegisterDevice("2")
+	s.RegisterDevice("3")
+
+	s.Registry().SetGroups("1", []string{"lobby"})
+	s.Registry().SetGroups("3", []string{"lobby", "warehouse"})
+
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest("POST", "/groups/lobby/commands", strings.NewReader(`{"cmd": "CHECK"}`)))
+
+	var results []adminGroupResult
+	json.NewDecoder(w.Body).Decode(&results)
+
+	if w.Code != http.StatusAccepted || len(results) != 2 || results[0].Command == nil || results[0].Command.SN != "1" || results[1].Command == nil || results[1].Command.SN != "3" {
+		t.Errorf("expected command queued on group members but returned %d %+v", w.Code, results)
+		t.FailNow()
+	}
+
+	cmds, _ := s.getCommandQueue("2")
+	if len(cmds) != 0 {
+		t.Errorf("expected no command on non member but returned %d", len(cmds))
+	}
+}
+
+func TestAdminInjectedCommand(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("1")
+	s.Registry().SetGroups("1", []string{"lobby"})
+
+	testCases := []struct {
+		path string
+		body string
+	}{
+		{"/devices/1/commands", `{"cmd": "CHECK", "payload": "x\nC:99:CLEAR DATA"}`},
+		{"/devices/1/commands", `{"cmd": "CHECK\r\nC:99:CLEAR DATA"}`},
+		{"/devices/1/commands", `{"cmd": "DATA\tQUERY"}`},
+		{"/groups/lobby/commands", `{"cmd": "CHECK", "payload": "x\nC:99:CLEAR DATA"}`},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(w, httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 on %s but returned %d", tc.body, w.Code)
+			t.FailNow()
+		}
+	}
+
+	cmds, _ := s.getCommandQueue("1")
+	if len(cmds) != 0 {
+		t.Errorf("expected no command queued but returned %d", len(cmds))
+	}
+}
+
+func TestAdminEventsStopped(t *testing.T) {
+	s := NewServer(&ServerOption{Address: "127.0.0.1:0", AdminPrefix: "/admin"}, adminHook{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Start(ctx)
+	}()
+
+	select {
+	case <-s.Ready():
+	case err := <-errc:
+		t.Error(err)
+		t.FailNow()
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/admin/events")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+
+	// stream finished, so shutdown doesn't wait for it
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("server not stopped")
+		t.FailNow()
+	}
+
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	}
+}
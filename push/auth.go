@@ -0,0 +1,203 @@
+package push
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// default authentication setting
+const (
+	defaultMaxAuthFailures = 5
+	defaultAuthBanDuration = time.Minute * 5
+)
+
+// address banned after failures of this many devices, serial
+// number is chosen by requester so can't be trusted alone
+const addressFailureFactor = 5
+
+// SecretStore provide push comm key of allowed devices
+type SecretStore interface {
+	// Secret return comm key of device.
+	// false if device is not allowed
+	Secret(sn string) (string, bool)
+}
+
+// InMemorySecretStore implement SecretStore using memory
+type InMemorySecretStore struct {
+	secrets sync.Map
+}
+
+// Set allow device with given comm key
+func (s *InMemorySecretStore) Set(sn string, key string) {
+	s.secrets.Store(sn, key)
+}
+
+// Remove revoke device access
+func (s *InMemorySecretStore) Remove(sn string) {
+	s.secrets.Delete(sn)
+}
+
+// Secret implements SecretStore.Secret
+func (s *InMemorySecretStore) Secret(sn string) (string, bool) {
+	v, ok := s.secrets.Load(sn)
+	if !ok {
+		return "", false
+	}
+
+	key, ok := v.(string)
+
+	return key, ok
+}
+
+// NewSecretStore create in memory secret store
+func NewSecretStore() *InMemorySecretStore {
+	return &InMemorySecretStore{}
+}
+
+// authFailure track failed attempts of single source
+type authFailure struct {
+	count       int
+	since       time.Time
+	bannedUntil time.Time
+}
+
+// expired check whether failures and ban of source are over
+func (f *authFailure) expired(now time.Time, duration time.Duration) bool {
+	return now.Sub(f.since) > duration && !now.Before(f.bannedUntil)
+}
+
+// authLimiter ban requester which fail authentication too many
+// times. attempts tracked per serial number and address, so
+// devices behind same NAT don't ban each other, and per address,
+// so requester rotating serial number banned as well
+type authLimiter struct {
+	sync.Mutex
+
+	max      int
+	duration time.Duration
+
+	// keyed by sn@address and address
+	failures map[string]*authFailure
+	evicted  time.Time
+}
+
+func newAuthLimiter(max int, duration time.Duration) *authLimiter {
+	if max < 1 {
+		max = defaultMaxAuthFailures
+	}
+
+	if duration <= 0 {
+		duration = defaultAuthBanDuration
+	}
+
+	return &authLimiter{
+		max:      max,
+		duration: duration,
+		failures: make(map[string]*authFailure),
+	}
+}
+
+// banned check whether device or its address currently banned
+func (l *authLimiter) banned(sn string, addr string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, key := range []string{addr, sn + "@" + addr} {
+		if f, ok := l.failures[key]; ok && now.Before(f.bannedUntil) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail record failed attempt, device banned when reach max failures
+// within ban duration, its address when reach factor times of it
+func (l *authLimiter) fail(sn string, addr string, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.count(sn+"@"+addr, l.max, now)
+	l.count(addr, l.max*addressFailureFactor, now)
+
+	// forget old failures, so random sources don't pile up
+	if now.Sub(l.evicted) > l.duration {
+		for key, f := range l.failures {
+			if f.expired(now, l.duration) {
+				delete(l.failures, key)
+			}
+		}
+
+		l.evicted = now
+	}
+}
+
+// count add failure of source, banned when reach max
+func (l *authLimiter) count(key string, max int, now time.Time) {
+	f, ok := l.failures[key]
+	if !ok || f.expired(now, l.duration) {
+		f = &authFailure{since: now}
+		l.failures[key] = f
+	}
+
+	f.count++
+	if f.count >= max {
+		f.bannedUntil = now.Add(l.duration)
+	}
+}
+
+// reset forget failed attempts of device. failures of its address
+// kept, so success of one device doesn't clear attempts of others
+func (l *authLimiter) reset(sn string, addr string) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.failures, sn+"@"+addr)
+}
+
+// authenticate reject request from unknown device or with
+// invalid comm key. comm key is required on every endpoint,
+// except device on push protocol 3.x which may present session
+// token issued upon registry authenticated by comm key
+func (s *Server) authenticate(accept credential, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.option.Secrets == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		query := r.URL.Query()
+		sn := query.Get("SN")
+		addr := remoteIP(r.RemoteAddr)
+
+		if s.authLimiter.banned(sn, addr, now) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		secret, known := s.option.Secrets.Secret(sn)
+
+		ok := known && subtle.ConstantTimeCompare([]byte(query.Get("pushcommkey")), []byte(secret)) == 1
+		if !ok && known {
+			if sess, found := s.sessions.get(sn); found {
+				ok = sess.valid(sessionToken(r), accept)
+			}
+		}
+
+		if !ok {
+			log.Printf("authentication failed, SN: %q, address: %s\n", sn, addr)
+
+			s.authLimiter.fail(sn, addr, now)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		s.authLimiter.reset(sn, addr)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package push
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	secrets := NewSecretStore()
+	secrets.Set("123456789", "secret")
+	secrets.Set("555555555", "other")
+
+	s := NewServer(&ServerOption{Secrets: secrets, MaxAuthFailures: 2})
+	h := s.authenticate(sessionID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testCases := []struct {
+		path   string
+		query  string
+		status int
+	}{
+		{"/iclock/cdata", "SN=123456789&pushcommkey=secret", http.StatusOK},
+		{"/iclock/cdata", "SN=987654321&pushcommkey=secret", http.StatusForbidden},
+
+		// comm key required beyond initial exchange
+		{"/iclock/getrequest", "SN=123456789", http.StatusForbidden},
+		{"/iclock/devicecmd", "SN=123456789&pushcommkey=invalid", http.StatusForbidden},
+
+		// banned after max failures
+		{"/iclock/cdata", "SN=123456789&pushcommkey=secret", http.StatusTooManyRequests},
+
+		// other device on same address not affected
+		{"/iclock/getrequest", "SN=555555555&pushcommkey=other", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path+"?"+tc.query, nil))
+
+		if w.Code != tc.status {
+			t.Errorf("expected status %d on %s but returned %d", tc.status, tc.query, w.Code)
+			t.FailNow()
+		}
+	}
+}
+
+func TestAuthenticateRotatingSN(t *testing.T) {
+	secrets := NewSecretStore()
+	secrets.Set("123456789", "secret")
+
+	s := NewServer(&ServerOption{Secrets: secrets, MaxAuthFailures: 2})
+	h := s.authenticate(sessionID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// each serial number fail once, address banned after all
+	for i := 0; i < 2*addressFailureFactor; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/iclock/cdata?SN="+strconv.Itoa(i)+"&pushcommkey=guess", nil))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status 403 on attempt %d but returned %d", i, w.Code)
+			t.FailNow()
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/iclock/cdata?SN=123456789&pushcommkey=secret", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected address banned but returned %d", w.Code)
+	}
+}
+
+func TestAuthLimiterEviction(t *testing.T) {
+	l := newAuthLimiter(2, time.Minute)
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		l.fail(strconv.Itoa(i), "192.0.2.1", now)
+	}
+
+	if !l.banned("1", "192.0.2.1", now) {
+		t.Error("expected address banned")
+		t.FailNow()
+	}
+
+	// failure after ban duration forget the rest
+	later := now.Add(time.Minute * 2)
+	l.fail("0", "192.0.2.2", later)
+
+	if len(l.failures) != 2 {
+		t.Errorf("expected old failures evicted but kept %d", len(l.failures))
+	}
+
+	if l.banned("1", "192.0.2.1", later) {
+		t.Error("expected ban lifted")
+	}
+}
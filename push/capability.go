@@ -0,0 +1,163 @@
+package push
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrCapabilityNotSupported returned when command require
+// function which device reported as not supported
+var ErrCapabilityNotSupported = errors.New("Command not supported by device capabilities")
+
+// biometric type, position on MultiBioDataSupport option
+const (
+	BioGeneral = iota
+	BioFingerPrint
+	BioFace
+	BioVoice
+	BioIris
+	BioRetina
+	BioPalmPrint
+	BioFingerVein
+	BioPalm
+	BioVisibleFace
+)
+
+// option keys of function flags
+var capabilityKeys = []string{"FingerFunOn", "FaceFunOn", "PvFunOn", "PhotoFunOn", "UserPicURLFunOn", "MultiBioDataSupport"}
+
+// Capabilities describe functions supported by device,
+// parsed from device options and INFO feature flags
+type Capabilities struct {
+	// whether device reported its capabilities. commands
+	// never refused for device which doesn't report them
+	Known bool `json:"known"`
+
+	FingerPrint        bool   `json:"fingerprint"`
+	FingerPrintVersion string `json:"fingerprint_version,omitempty"`
+
+	Face        bool   `json:"face"`
+	FaceVersion string `json:"face_version,omitempty"`
+
+	Palm        bool   `json:"palm"`
+	PalmVersion string `json:"palm_version,omitempty"`
+
+	UserPhoto bool `json:"user_photo"`
+
+	// supported biometric types of multi biometric data,
+	// see const biometric type
+	MultiBio []int `json:"multi_bio,omitempty"`
+}
+
+// ParseCapabilities parse capabilities from device options, i.e.
+// comma separated parameters sent on registry, combined with
+// function flags of reported information
+func ParseCapabilities(option string, info DeviceInfo) Capabilities {
+	options := parseOptions([]byte(option), ",")
+	flag := func(keys ...string) bool {
+		for _, key := range keys {
+			if options[key] == "1" {
+				return true
+			}
+		}
+
+		return false
+	}
+	version := func(keys ...string) string {
+		for _, key := range keys {
+			if v := options[key]; v != "" {
+				return v
+			}
+		}
+
+		return ""
+	}
+
+	c := Capabilities{
+		FingerPrint:        flag("FingerFunOn") || info.Supports(FeatureFingerPrint),
+		FingerPrintVersion: version("FPVersion", "~ZKFPVersion"),
+		Face:               flag("FaceFunOn") || info.Supports(FeatureFace),
+		FaceVersion:        version("FaceVersion", "~FaceVersion"),
+		Palm:               flag("PvFunOn"),
+		PalmVersion:        version("PvVersion"),
+		UserPhoto:          flag("PhotoFunOn", "UserPicURLFunOn") || info.Supports(FeatureUserPhoto),
+	}
+
+	if c.FingerPrintVersion == "" {
+		c.FingerPrintVersion = info.FingerPrintVersion
+	}
+
+	if c.FaceVersion == "" {
+		c.FaceVersion = info.FaceVersion
+	}
+
+	// colon separated flag per biometric type
+	for i, f := range strings.Split(options["MultiBioDataSupport"], ":") {
+		if f == "1" {
+			c.MultiBio = append(c.MultiBio, i)
+		}
+	}
+
+	c.FingerPrint = c.FingerPrint || c.SupportsBio(BioFingerPrint)
+	c.Face = c.Face || c.SupportsBio(BioFace) || c.SupportsBio(BioVisibleFace)
+	c.Palm = c.Palm || c.SupportsBio(BioPalm)
+
+	// device which report any function flag known to report all,
+	// older firmware only send INFO feature flags
+	c.Known = info.Features != ""
+	for _, key := range capabilityKeys {
+		if _, ok := options[key]; ok {
+			c.Known = true
+		}
+	}
+
+	return c
+}
+
+// SupportsBio check whether multi biometric data of type supported
+func (c Capabilities) SupportsBio(bioType int) bool {
+	for _, t := range c.MultiBio {
+		if t == bioType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allow check whether command can be sent to device,
+// return ErrCapabilityNotSupported when device lack
+// function required by command
+func (c Capabilities) Allow(cmd Command) error {
+	if !c.Known {
+		return nil
+	}
+
+	line := strings.ToUpper(strings.TrimSpace(cmd.CMD + " " + string(cmd.Payload)))
+
+	var supported = true
+	switch {
+	case strings.HasPrefix(line, "ENROLL_FP"),
+		strings.HasPrefix(line, "DATA UPDATE FINGERTMP"),
+		strings.HasPrefix(line, "DATA QUERY FINGERTMP"):
+		supported = c.FingerPrint
+	case strings.HasPrefix(line, "DATA UPDATE FACE"),
+		strings.HasPrefix(line, "DATA QUERY FACE"):
+		supported = c.Face
+	case strings.HasPrefix(line, "DATA UPDATE USERPIC"),
+		strings.HasPrefix(line, "DATA UPDATE BIOPHOTO"):
+		supported = c.UserPhoto
+	case strings.HasPrefix(line, "DATA UPDATE BIODATA"),
+		strings.HasPrefix(line, "ENROLL_BIO"):
+		if t, err := strconv.Atoi(extractValue([]byte(line), "TYPE", []byte("\t")).ToString()); err == nil {
+			supported = c.SupportsBio(t)
+		}
+	}
+
+	if !supported {
+		return ErrCapabilityNotSupported
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package push
+
+import (
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	c := ParseCapabilities("DeviceType=att,~DeviceName=F22,FingerFunOn=1,FPVersion=10,FaceFunOn=0,MultiBioDataSupport=0:1:0:0:0:0:0:0:1:0", DeviceInfo{})
+
+	if !c.Known || !c.FingerPrint || c.FingerPrintVersion != "10" || c.Face || !c.Palm {
+		t.Errorf("unexpected capabilities %+v", c)
+	}
+
+	// legacy device only report INFO function flags
+	c = ParseCapabilities("all", DeviceInfo{Features: "011", FaceVersion: "7"})
+	if !c.Known || c.FingerPrint || !c.Face || c.FaceVersion != "7" || !c.UserPhoto {
+		t.Errorf("unexpected capabilities %+v", c)
+	}
+
+	c = ParseCapabilities("all", DeviceInfo{})
+	if c.Known {
+		t.Errorf("expected unknown capabilities but returned %+v", c)
+	}
+}
+
+func TestCapabilitiesAllow(t *testing.T) {
+	c := ParseCapabilities("FingerFunOn=1,FaceFunOn=0,MultiBioDataSupport=0:1:0:0:0:0:0:0:0:0", DeviceInfo{})
+
+	cases := []struct {
+		cmd     Command
+		allowed bool
+	}{
+		{Command{CMD: "DATA UPDATE FINGERTMP", Payload: []byte("PIN=1\tFID=0")}, true},
+		{Command{CMD: "DATA", Payload: []byte("UPDATE FACE PIN=1\tFID=0")}, false},
+		{Command{CMD: "DATA UPDATE BIODATA", Payload: []byte("Pin=1\tNo=0\tType=1")}, true},
+		{Command{CMD: "DATA UPDATE BIODATA", Payload: []byte("Pin=1\tNo=0\tType=9")}, false},
+		{Command{CMD: "REBOOT"}, true},
+	}
+
+	for _, tc := range cases {
+		err := c.Allow(tc.cmd)
+		if tc.allowed && err != nil || !tc.allowed && err != ErrCapabilityNotSupported {
+			t.Errorf("unexpected result of %s %s: %v", tc.cmd.CMD, tc.cmd.Payload, err)
+		}
+	}
+
+	// nothing refused when capabilities unknown
+	if err := (Capabilities{}).Allow(Command{CMD: "DATA UPDATE FACE"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeviceOptionRoundTrip(t *testing.T) {
+	d := Device{SN: "123456789", Option: "FingerFunOn=1,FaceFunOn=1", PushVersion: "2.4.1", Language: LangEN}
+
+	b, err := d.Marshall()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	var decoded Device
+	if err := Unmarshall(b, &decoded); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if decoded.Option != d.Option || decoded.PushVersion != d.PushVersion || !decoded.Capabilities.Face {
+		t.Errorf("expected %+v but returned %+v", d, decoded)
+	}
+}
+
+func TestMarshallNotPooled(t *testing.T) {
+	device, _ := Device{SN: "123456789", PushVersion: "2.4.1"}.Marshall()
+	exchange, _ := ExchangeCommand{SN: "123456789", Delay: 10}.Marshall()
+
+	want := [][]byte{append([]byte(nil), device...), append([]byte(nil), exchange...)}
+
+	// every pooled buffer reused afterwards
+	for i := 0; i < bufferPoolSize*2; i++ {
+		Device{SN: "987654321", Option: "FingerFunOn=1,FaceFunOn=1"}.Marshall()
+		ExchangeCommand{SN: "987654321", Delay: 30, TransFlag: "TransData AttLog"}.Marshall()
+	}
+
+	if string(device) != string(want[0]) || string(exchange) != string(want[1]) {
+		t.Errorf("expected marshalled bytes kept but returned %q %q", device, exchange)
+	}
+}
+
+func TestRefuseUnsupportedCommand(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+	s.registry.Update(Device{SN: "123456789", Option: "FingerFunOn=1,FaceFunOn=0"}, 30)
+
+	if err := s.putCommandQueue("123456789", Command{ID: "1", CMD: "DATA UPDATE FACE", Payload: []byte("PIN=1")}); err != ErrCapabilityNotSupported {
+		t.Errorf("expected capability not supported but returned %v", err)
+	}
+
+	if err := s.putCommandQueue("123456789", Command{ID: "2", CMD: "DATA UPDATE FINGERTMP", Payload: []byte("PIN=1")}); err != nil {
+		t.Error(err)
+	}
+}
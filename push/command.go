@@ -1,13 +1,31 @@
 package push
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 )
 
 type CommandCallback func(CommandResponse)
 
+// CommandFailureCallback called when command dropped
+// without receiving response from device
+type CommandFailureCallback func(Command, error)
+
+// CommandPriority define delivery lane of command.
+// higher priority commands sent first
+type CommandPriority int
+
+// command priorities
+const (
+	PriorityNormal CommandPriority = iota
+	PriorityHigh
+	PriorityUrgent
+)
+
 type Command struct {
 	// command id which correspondence to operation id
 	// see const commands
@@ -20,6 +38,36 @@ type Command struct {
 	Payload []byte
 
 	Callback CommandCallback
+
+	// delivery lane, urgent command like REBOOT or unlock
+	// skip ahead of bulk data commands
+	Priority CommandPriority
+
+	// re-delivery policy when device doesn't acknowledge command.
+	// nil means command sent once without waiting acknowledge
+	Retry *RetryPolicy
+
+	// command dropped from queue when not delivered
+	// before expire time. zero means never expired
+	ExpireAt time.Time
+
+	// called when command expired or max attempts reached
+	OnFailure CommandFailureCallback
+}
+
+// size return length of marshalled command
+func (c Command) size() int {
+	b, err := c.Marshal()
+	if err != nil {
+		return 0
+	}
+
+	return len(b)
+}
+
+// expired check whether command passed its expire time
+func (c Command) expired(now time.Time) bool {
+	return !c.ExpireAt.IsZero() && now.After(c.ExpireAt)
 }
 
 func (c *Command) Marshal() ([]byte, error) {
@@ -37,34 +85,161 @@ func (c *Command) Marshal() ([]byte, error) {
 		buf.Write(c.Payload)
 	}
 
-	return buf.Bytes(), nil
+	// every command must be terminated by new line
+	buf.Write(lf)
+
+	// copy, as buffer returned to pool
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+
+	return b, nil
 }
 
+// key of file content on GetFile result
+var fileContentKey = []byte("&Content=")
+
 type CommandResponse struct {
-	ID     string
-	Return int
-	CMD    string
+	ID     string `json:"id"`
+	Return int    `json:"return"`
+	CMD    string `json:"cmd"`
 
-	Payload []byte
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// error of command result return code (Appendix 1)
+var returnErrors = map[int]error{
+	ParameterIncorrect:         ErrParameterIncorrect,
+	MissmatchUserPhotoDataSize: ErrUserPhotoSizeMismatch,
+	ReadingWritingIncorrect:    ErrReadingWritingIncorrect,
+	MissmatchTemplateDataSize:  ErrTemplateSizeMismatch,
+	UserPINNotExists:           ErrUserPINNotExists,
+	LimitedCapacity:            ErrLimitedCapacity,
+	NotSupported:               ErrNotSupported,
+	CommandTimeout:             ErrCommandTimeout,
+	EquipmentIsBusy:            ErrEquipmentIsBusy,
+	DataTooLong:                ErrDataTooLong,
+
+	FingerPrintExists:                       ErrFingerPrintExists,
+	FingerPrintEnrollmentFailed:             ErrFingerPrintEnrollmentFailed,
+	FingerPrintExistsInDatabase:             ErrFingerPrintExistsInDatabase,
+	FingerPrintEnrollmentCanceled:           ErrFingerPrintEnrollmentCanceled,
+	FingerPrintEnrollmentFailedDeviceIsBusy: ErrFingerPrintEnrollmentFailedDeviceBusy,
+	IllegalFingerPrintFormat:                ErrIllegalFingerPrintFormat,
+	IllegalFingerPrintTemplate:              ErrIllegalFingerPrintTemplate,
+}
+
+// Err return error corresponding to return code, nil if succeed
+func (c CommandResponse) Err() error {
+	if c.IsOK() {
+		return nil
+	}
+
+	if err, ok := returnErrors[c.Return]; ok {
+		return err
+	}
+
+	return fmt.Errorf("Command %s failed with return code %d", c.CMD, c.Return)
 }
 
 // IsOK check whether response is valid
 // see const response
 func (c CommandResponse) IsOK() bool {
+	// GetFile and PutFile return size of transferred file,
+	// UPGRADE return zero like other commands
+	if c.CMD == "GetFile" || c.CMD == "PutFile" {
+		return c.Return >= 0
+	}
+
 	return c.Return == 0
 }
 
+// returnsData check whether result of command followed by payload
+// lines, results of other commands may be batched on single request
+func returnsData(cmd string) bool {
+	return cmd == "INFO" || strings.HasPrefix(cmd, "GET OPTION")
+}
+
 // Unmarshall implement PayloadDecoder interface
 func (c *CommandResponse) Unmarshall(b []byte) error {
+	// result on first line, followed by payload
+	// of command which return data, e.g. INFO.
+	// file content of GetFile is the last field
+	var payload []byte
+	if idx := bytes.Index(b, fileContentKey); idx != -1 {
+		b, payload = b[:idx], b[idx+len(fileContentKey):]
+	} else if idx := bytes.IndexByte(b, '\n'); idx != -1 {
+		b, payload = b[:idx], bytes.TrimSpace(b[idx+1:])
+	}
+
 	c.ID = extractValue(b, "ID").ToString()
 	c.Return = extractValue(b, "Return").ToInt()
-	c.CMD = extractValue(b, "CMD").ToString()
+	c.CMD = strings.TrimSpace(extractValue(b, "CMD").ToString())
 
-	// TODO: get payload
+	c.Payload = nil
+	if len(payload) > 0 && (c.CMD == "GetFile" || returnsData(c.CMD)) {
+		c.Payload = payload
+	}
 
 	return nil
 }
 
+// splitCommandResponses split devicecmd body into results, device
+// may report several commands on single request, one per line.
+// payload lines only follow result of command which return data,
+// file content of GetFile always the last one
+func splitCommandResponses(b []byte) ([]CommandResponse, error) {
+	var (
+		records [][]byte
+		data    bool
+	)
+
+	for len(b) > 0 {
+		line := b
+		if idx := bytes.IndexByte(b, '\n'); idx != -1 {
+			line = b[:idx]
+		}
+
+		// file content of GetFile may span lines, either raw
+		// or mime base64, keep remaining body as it is
+		if bytes.HasPrefix(line, []byte("ID=")) && bytes.Contains(line, fileContentKey) {
+			records = append(records, append([]byte(nil), b...))
+			break
+		}
+
+		b = b[len(line):]
+		if len(b) > 0 {
+			b = b[1:]
+		}
+
+		line = bytes.TrimRight(line, "\r")
+		if bytes.HasPrefix(line, []byte("ID=")) {
+			records = append(records, append([]byte(nil), line...))
+			data = returnsData(strings.TrimSpace(extractValue(line, "CMD").ToString()))
+			continue
+		}
+
+		// stray line of command which return nothing
+		if !data || len(records) == 0 {
+			continue
+		}
+
+		last := len(records) - 1
+		records[last] = append(append(records[last], '\n'), line...)
+	}
+
+	responses := make([]CommandResponse, 0, len(records))
+	for _, record := range records {
+		var response CommandResponse
+		if err := Unmarshall(record, &response); err != nil {
+			return nil, err
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
+
 func generateUUID() uuid.UUID {
 	guid, err := uuid.NewV4()
 	if err != nil {
@@ -0,0 +1,187 @@
+package push
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCronSpec returned when schedule spec can't be parsed
+var ErrInvalidCronSpec = errors.New("Invalid cron spec")
+
+// predefined cron specs
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField define allowed range of spec field
+type cronField struct {
+	min, max int
+}
+
+// spec fields: minute, hour, day of month, month, day of week
+var cronFields = []cronField{
+	{0, 59},
+	{0, 23},
+	{1, 31},
+	{1, 12},
+	{0, 6},
+}
+
+// cronSpec is parsed five fields cron expression,
+// each field is bit set of matching values
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+
+	// day of month and day of week are matched by
+	// either one when both restricted, like standard cron
+	domAny, dowAny bool
+}
+
+// parseCron parse standard five fields cron expression, e.g.
+// "0 2 * * *" or "*/15 8-17 * * 1-5", or predefined descriptor
+// e.g. "@daily". day of week 7 is accepted as sunday
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if v, ok := cronDescriptors[spec]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, ErrInvalidCronSpec
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		f := cronFields[i]
+
+		// sunday either 0 or 7
+		if i == 4 {
+			f.max = 7
+		}
+
+		set, err := parseCronField(field, f)
+		if err != nil {
+			return nil, err
+		}
+
+		sets[i] = set
+	}
+
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSpec{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parse comma separated list of value,
+// range or wildcard, each with optional step
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i != -1 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return 0, ErrInvalidCronSpec
+			}
+
+			step = n
+			item = item[:i]
+		}
+
+		from, to := f.min, f.max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+
+			var err1, err2 error
+			from, err1 = strconv.Atoi(bounds[0])
+			to, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, ErrInvalidCronSpec
+			}
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return 0, ErrInvalidCronSpec
+			}
+
+			from, to = n, n
+		}
+
+		if from < f.min || to > f.max || from > to {
+			return 0, ErrInvalidCronSpec
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// matchDay check day of month and day of week
+func (c *cronSpec) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// next return first matching time after given time,
+// zero if none within five years
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,279 @@
+package push
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// default number of polls before unacknowledged
+// command considered lost
+const defaultAckPolls = 3
+
+// RetryPolicy define how command which sent to device
+// but never acknowledged should be re-delivered
+type RetryPolicy struct {
+	// maximum delivery attempts, including first delivery.
+	// zero or less considered single attempt
+	MaxAttempts int `json:"max_attempts"`
+
+	// re-deliver command when device polls N times
+	// without sending command result
+	AckPolls int `json:"ack_polls"`
+
+	// re-deliver command when no command result received
+	// within given duration
+	AckTimeout time.Duration `json:"ack_timeout"`
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+
+	return p.MaxAttempts
+}
+
+// delivery track command delivery state
+type delivery struct {
+	cmd Command
+
+	// number of times command sent to device
+	attempts int
+
+	// number of device polls since last sent
+	polls int
+
+	// last sent time
+	sentAt time.Time
+}
+
+// due check whether in-flight command should be considered lost
+func (d *delivery) due(now time.Time) bool {
+	policy := d.cmd.Retry
+
+	if policy.AckPolls == 0 && policy.AckTimeout == 0 {
+		return d.polls >= defaultAckPolls
+	}
+
+	if policy.AckPolls > 0 && d.polls >= policy.AckPolls {
+		return true
+	}
+
+	return policy.AckTimeout > 0 && now.Sub(d.sentAt) >= policy.AckTimeout
+}
+
+// batchLimit restrict amount of commands sent on single poll.
+// zero value means unlimited
+type batchLimit struct {
+	commands int
+	bytes    int
+}
+
+// fit check whether command of given size still fit into
+// batch which already contains n commands of total size
+func (l batchLimit) fit(n, total, size int) bool {
+	// always send at least single command, otherwise
+	// oversized command will be stuck in queue
+	if n == 0 {
+		return true
+	}
+
+	if l.commands > 0 && n >= l.commands {
+		return false
+	}
+
+	return l.bytes <= 0 || total+size <= l.bytes
+}
+
+// failure describe command which dropped from queue
+type failure struct {
+	cmd Command
+	err error
+}
+
+// commandQueue hold pending and in-flight commands of single device
+type commandQueue interface {
+	// put add commands at the end of queue
+	put(cmds ...Command)
+
+	// commands return snapshot of pending commands
+	commands() []Command
+
+	// poll return commands which should be sent on device poll
+	poll(now time.Time, limit batchLimit) ([]Command, []failure)
+
+	// sweep drop expired and lost commands
+	sweep(now time.Time) []failure
+
+	// ack remove in-flight command
+	ack(id string) bool
+}
+
+// deviceQueue hold pending and in-flight commands
+// of single device in memory
+type deviceQueue struct {
+	sync.Mutex
+
+	// commands waiting to be sent, oldest first
+	pending []*delivery
+
+	// commands sent but not yet acknowledged.
+	// only commands with retry policy are tracked
+	inflight []*delivery
+}
+
+func newDeviceQueue() *deviceQueue {
+	return &deviceQueue{}
+}
+
+// put add commands at the end of queue
+func (q *deviceQueue) put(cmds ...Command) {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, cmd := range cmds {
+		q.pending = append(q.pending, &delivery{cmd: cmd})
+	}
+}
+
+// commands return snapshot of pending commands
+func (q *deviceQueue) commands() []Command {
+	q.Lock()
+	defer q.Unlock()
+
+	cmds := make([]Command, 0, len(q.pending))
+	for _, d := range q.pending {
+		cmds = append(cmds, d.cmd)
+	}
+
+	return cmds
+}
+
+// poll is called on every device poll. it re-queue lost commands,
+// drop expired ones and return commands which should be sent.
+// commands which exceed the limit stay queued for next poll
+func (q *deviceQueue) poll(now time.Time, limit batchLimit) ([]Command, []failure) {
+	q.Lock()
+	defer q.Unlock()
+
+	failed := q.checkInflight(now, true)
+	failed = append(failed, q.dropExpired(now)...)
+
+	// higher priority first, keep queue order within same priority
+	sort.SliceStable(q.pending, func(i, j int) bool {
+		return q.pending[i].cmd.Priority > q.pending[j].cmd.Priority
+	})
+
+	var (
+		cmds    = make([]Command, 0, len(q.pending))
+		pending = make([]*delivery, 0)
+		total   int
+	)
+
+	for _, d := range q.pending {
+		size := d.cmd.size()
+		if len(pending) > 0 || !limit.fit(len(cmds), total, size) {
+			// keep order of the rest
+			pending = append(pending, d)
+			continue
+		}
+
+		d.attempts++
+		d.polls = 0
+		d.sentAt = now
+
+		if d.cmd.Retry != nil {
+			q.inflight = append(q.inflight, d)
+		}
+
+		cmds = append(cmds, d.cmd)
+		total += size
+	}
+	q.pending = pending
+
+	return cmds, failed
+}
+
+// sweep drop expired and lost commands without
+// counting as device poll
+func (q *deviceQueue) sweep(now time.Time) []failure {
+	q.Lock()
+	defer q.Unlock()
+
+	failed := q.checkInflight(now, false)
+
+	return append(failed, q.dropExpired(now)...)
+}
+
+// ack remove in-flight command, return false
+// if command not found
+func (q *deviceQueue) ack(id string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, d := range q.inflight {
+		if d.cmd.ID == id {
+			q.inflight = append(q.inflight[:i], q.inflight[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkInflight move lost commands back to the front of queue
+// or give up when max attempts reached. caller must hold lock
+func (q *deviceQueue) checkInflight(now time.Time, polled bool) []failure {
+	var (
+		failed   []failure
+		requeue  []*delivery
+		inflight = q.inflight[:0]
+	)
+
+	for _, d := range q.inflight {
+		if polled {
+			d.polls++
+		}
+
+		if !d.due(now) {
+			inflight = append(inflight, d)
+			continue
+		}
+
+		if d.attempts >= d.cmd.Retry.maxAttempts() {
+			failed = append(failed, failure{cmd: d.cmd, err: ErrCommandUnacknowledged})
+			continue
+		}
+
+		requeue = append(requeue, d)
+	}
+
+	q.inflight = inflight
+
+	// re-delivered commands take precedence
+	if len(requeue) > 0 {
+		q.pending = append(requeue, q.pending...)
+	}
+
+	return failed
+}
+
+// dropExpired remove expired commands from queue.
+// caller must hold lock
+func (q *deviceQueue) dropExpired(now time.Time) []failure {
+	var failed []failure
+
+	pending := q.pending[:0]
+	for _, d := range q.pending {
+		if d.cmd.expired(now) {
+			failed = append(failed, failure{cmd: d.cmd, err: ErrCommandExpired})
+			continue
+		}
+
+		pending = append(pending, d)
+	}
+	q.pending = pending
+
+	return failed
+}
@@ -0,0 +1,107 @@
+package push
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceQueueRetry(t *testing.T) {
+	q := newDeviceQueue()
+	q.put(Command{ID: "1", CMD: "REBOOT", Retry: &RetryPolicy{MaxAttempts: 2, AckPolls: 1}})
+
+	now := time.Now()
+
+	// first delivery
+	cmds, failed := q.poll(now, batchLimit{})
+	if len(cmds) != 1 || len(failed) != 0 {
+		t.Errorf("expected 1 command sent but returned %d", len(cmds))
+		t.FailNow()
+	}
+
+	// not acknowledged, re-delivered
+	cmds, failed = q.poll(now, batchLimit{})
+	if len(cmds) != 1 || len(failed) != 0 {
+		t.Errorf("expected command re-delivered but returned %d", len(cmds))
+		t.FailNow()
+	}
+
+	// max attempts reached
+	cmds, failed = q.poll(now, batchLimit{})
+	if len(cmds) != 0 || len(failed) != 1 || failed[0].err != ErrCommandUnacknowledged {
+		t.Errorf("expected command failed but returned %v", failed)
+		t.FailNow()
+	}
+}
+
+func TestDeviceQueueAck(t *testing.T) {
+	q := newDeviceQueue()
+	q.put(Command{ID: "1", CMD: "CHECK", Retry: &RetryPolicy{MaxAttempts: 3, AckPolls: 1}})
+
+	now := time.Now()
+	q.poll(now, batchLimit{})
+
+	if !q.ack("1") {
+		t.Error("expected command in-flight")
+		t.FailNow()
+	}
+
+	if cmds, _ := q.poll(now, batchLimit{}); len(cmds) != 0 {
+		t.Errorf("expected no re-delivery but returned %d", len(cmds))
+	}
+}
+
+func TestDeviceQueueExpire(t *testing.T) {
+	now := time.Now()
+
+	q := newDeviceQueue()
+	q.put(
+		Command{ID: "1", CMD: "CHECK", ExpireAt: now.Add(-time.Second)},
+		Command{ID: "2", CMD: "INFO", ExpireAt: now.Add(time.Minute)},
+	)
+
+	cmds, failed := q.poll(now, batchLimit{})
+	if len(cmds) != 1 || cmds[0].ID != "2" {
+		t.Errorf("expected only command 2 sent but returned %v", cmds)
+		t.FailNow()
+	}
+
+	if len(failed) != 1 || failed[0].err != ErrCommandExpired {
+		t.Errorf("expected command 1 expired but returned %v", failed)
+	}
+}
+
+func TestDeviceQueueBatch(t *testing.T) {
+	q := newDeviceQueue()
+	q.put(
+		Command{ID: "1", CMD: "DATA UPDATE USERINFO", Payload: []byte("PIN=1")},
+		Command{ID: "2", CMD: "DATA UPDATE USERINFO", Payload: []byte("PIN=2")},
+		Command{ID: "3", CMD: "REBOOT", Priority: PriorityUrgent},
+	)
+
+	now := time.Now()
+
+	cmds, _ := q.poll(now, batchLimit{commands: 2})
+	if len(cmds) != 2 || cmds[0].ID != "3" || cmds[1].ID != "1" {
+		t.Errorf("expected command 3 and 1 sent but returned %v", cmds)
+		t.FailNow()
+	}
+
+	cmds, _ = q.poll(now, batchLimit{bytes: 1})
+	if len(cmds) != 1 || cmds[0].ID != "2" {
+		t.Errorf("expected command 2 sent but returned %v", cmds)
+	}
+}
+
+func TestCommandMarshal(t *testing.T) {
+	cmd := Command{ID: "1", CMD: "REBOOT"}
+
+	b, err := cmd.Marshal()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if string(b) != "C:1:REBOOT\n" {
+		t.Errorf("expected new line terminated command but returned %q", b)
+	}
+}
@@ -7,19 +7,23 @@ import (
 // Device represent ZK biometric pheripheral
 type Device struct {
 	// device serial no
-	SN string
+	SN string `json:"sn"`
 
 	// device option
-	Option string
+	Option string `json:"option"`
+
+	// functions supported by device, parsed from option
+	// and reported information
+	Capabilities Capabilities `json:"capabilities"`
 
 	// push service version
-	PushVersion string
+	PushVersion string `json:"push_version"`
 
 	// device language (see const languages)
-	Language int
+	Language int `json:"language"`
 
-	// push comm key
-	PushCommKey string
+	// push comm key, never serialized
+	PushCommKey string `json:"-"`
 }
 
 // Marshall implement payload.Marshall operation
@@ -31,7 +35,7 @@ func (d Device) Marshall() ([]byte, error) {
 	buf.WriteString("SN=" + d.SN)
 
 	buf.Write(keyValueSeparator)
-	buf.WriteString("option=" + d.Option)
+	buf.WriteString("options=" + d.Option)
 
 	buf.Write(keyValueSeparator)
 	buf.WriteString("pushver=" + d.PushVersion)
@@ -42,7 +46,11 @@ func (d Device) Marshall() ([]byte, error) {
 	buf.Write(keyValueSeparator)
 	buf.WriteString("pushcommkey=" + d.PushCommKey)
 
-	return buf.Bytes(), nil
+	// copy, as buffer returned to pool
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+
+	return b, nil
 }
 
 // Unmarshall implement payload.Unmarshall interface
@@ -57,6 +65,7 @@ func (d *Device) Unmarshall(b []byte) error {
 	d.PushVersion = extractValue(b, "pushver").ToString()
 	d.Language = extractValue(b, "language").ToInt()
 	d.PushCommKey = extractValue(b, "pushcommkey").ToString()
+	d.Capabilities = ParseCapabilities(d.Option, DeviceInfo{})
 
 	return nil
 }
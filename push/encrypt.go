@@ -0,0 +1,118 @@
+package push
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// Cipher encrypt and decrypt payload exchanged with device which
+// negotiated encryption, implementing scheme of device firmware.
+// no implementation shipped, as the scheme isn't published. key is
+// comm key provisioned on secret store, never the one sent by
+// device on clear text query. serve over TLS when firmware
+// scheme not available
+type Cipher interface {
+	Encrypt(key string, plain []byte) ([]byte, error)
+	Decrypt(key string, data []byte) ([]byte, error)
+}
+
+// encryptionKey return provisioned comm key of device
+func (s *Server) encryptionKey(sn string) string {
+	if s.option.Secrets != nil {
+		if key, ok := s.option.Secrets.Secret(sn); ok {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// negotiateEncryption decide encryption of device upon initial exchange.
+// encryption enabled when server has cipher and device
+// has provisioned comm key
+func (s *Server) negotiateEncryption(d Device, cmd *ExchangeCommand) {
+	switch {
+	case s.option.Cipher == nil:
+		if cmd.Encrypt != 0 {
+			log.Printf("no cipher for device %s, encryption disabled\n", d.SN)
+		}
+		cmd.Encrypt = 0
+	case s.encryptionKey(d.SN) == "":
+		log.Printf("device %s has no comm key, encryption disabled\n", d.SN)
+		cmd.Encrypt = 0
+	default:
+		cmd.Encrypt = 1
+	}
+
+	s.registry.SetEncrypted(d.SN, cmd.Encrypt != 0)
+}
+
+// encryptedResponse buffer response so it can be
+// encrypted as a whole
+type encryptedResponse struct {
+	http.ResponseWriter
+
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *encryptedResponse) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *encryptedResponse) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// encrypted transparently decrypt request body and encrypt
+// response of device which negotiated encryption
+func (s *Server) encrypted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sn := r.URL.Query().Get("SN")
+
+		status, ok := s.registry.Get(sn)
+		if !ok || !status.Encrypted || s.option.Cipher == nil {
+			next(w, r)
+			return
+		}
+
+		key := s.encryptionKey(sn)
+
+		if r.Method == http.MethodPost {
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			plain, err := s.option.Cipher.Decrypt(key, body)
+			if err != nil {
+				log.Printf("failed to decrypt payload of %s: %v\n", sn, err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(plain))
+			r.ContentLength = int64(len(plain))
+		}
+
+		ew := &encryptedResponse{ResponseWriter: w, status: http.StatusOK}
+		next(ew, r)
+
+		b, err := s.option.Cipher.Encrypt(key, ew.buf.Bytes())
+		if err != nil {
+			log.Printf("failed to encrypt response of %s: %v\n", sn, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// content changed, drop plain text header
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(ew.status)
+		w.Write(b)
+	}
+}
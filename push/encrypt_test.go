@@ -0,0 +1,117 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// xorCipher is reversible test cipher
+type xorCipher struct{}
+
+func (c xorCipher) xor(key string, b []byte) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("Encryption key not available")
+	}
+
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ key[i%len(key)]
+	}
+
+	return out, nil
+}
+
+func (c xorCipher) Encrypt(key string, plain []byte) ([]byte, error) {
+	return c.xor(key, plain)
+}
+
+func (c xorCipher) Decrypt(key string, data []byte) ([]byte, error) {
+	return c.xor(key, data)
+}
+
+func TestNegotiateEncryption(t *testing.T) {
+	secrets := NewSecretStore()
+	secrets.Set("123456789", "secret")
+
+	testCases := []struct {
+		option  *ServerOption
+		sn      string
+		request int
+		encrypt int
+	}{
+		// no cipher, request ignored
+		{&ServerOption{Secrets: secrets}, "123456789", 1, 0},
+
+		// no provisioned comm key
+		{&ServerOption{Secrets: secrets, Cipher: xorCipher{}}, "987654321", 1, 0},
+		{&ServerOption{Cipher: xorCipher{}}, "123456789", 1, 0},
+
+		{&ServerOption{Secrets: secrets, Cipher: xorCipher{}}, "123456789", 0, 1},
+	}
+
+	for _, tc := range testCases {
+		s := NewServer(tc.option)
+		s.RegisterDevice(tc.sn)
+
+		cmd := &ExchangeCommand{SN: tc.sn, Encrypt: tc.request}
+		s.negotiateEncryption(Device{SN: tc.sn, PushCommKey: "sent"}, cmd)
+
+		status, _ := s.Registry().Get(tc.sn)
+		if cmd.Encrypt != tc.encrypt || status.Encrypted != (tc.encrypt != 0) {
+			t.Errorf("expected encrypt %d of %s but returned %d", tc.encrypt, tc.sn, cmd.Encrypt)
+		}
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	secrets := NewSecretStore()
+	secrets.Set("123456789", "secret")
+
+	hook := &locationHook{}
+	s := NewServer(&ServerOption{Secrets: secrets, Cipher: xorCipher{}, DeviceLocation: time.UTC}, hook)
+	if cmd := s.exchange(Device{SN: "123456789"}); cmd == nil || cmd.Encrypt != 1 {
+		t.Errorf("expected encryption negotiated but returned %+v", cmd)
+		t.FailNow()
+	}
+
+	body, _ := xorCipher{}.Encrypt("secret", []byte("1\t2019-05-20 08:00:00\t0\t1\n"))
+
+	w := httptest.NewRecorder()
+	s.encrypted(s.handleUpload)(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", bytes.NewReader(body)))
+
+	if len(hook.logs) != 1 || hook.logs[0].PIN != "1" {
+		t.Errorf("expected decrypted attendance log but returned %+v", hook.logs)
+		t.FailNow()
+	}
+
+	resp, _ := xorCipher{}.Decrypt("secret", w.Body.Bytes())
+	if w.Code != 200 || string(resp) != "OK" {
+		t.Errorf("expected encrypted OK but returned %d %q", w.Code, resp)
+		t.FailNow()
+	}
+
+	// queued command encrypted on poll
+	s.putCommandQueue("123456789", Command{ID: "1", CMD: "INFO"})
+
+	w = httptest.NewRecorder()
+	s.encrypted(s.handleCommand)(w, httptest.NewRequest("GET", "/iclock/getrequest?SN=123456789", nil))
+
+	resp, _ = xorCipher{}.Decrypt("secret", w.Body.Bytes())
+	if string(resp) != "C:1:INFO\n" {
+		t.Errorf("expected encrypted command but returned %q", resp)
+		t.FailNow()
+	}
+
+	// body which can't be decrypted rejected
+	s.option.Secrets = NewSecretStore()
+
+	w = httptest.NewRecorder()
+	s.encrypted(s.handleUpload)(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", bytes.NewReader(body)))
+
+	if w.Code != 400 {
+		t.Errorf("expected status 400 but returned %d", w.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package push
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	EventAttendance    = "attendance"
+	EventOperationLog  = "operation_log"
+	EventDeviceOnline  = "device_online"
+	EventDeviceOffline = "device_offline"
+	EventCommandResult = "command_result"
+)
+
+// default number of events buffered per subscriber
+const defaultEventBuffer = 64
+
+// Event describe something happened on device
+type Event struct {
+	Type string    `json:"type"`
+	SN   string    `json:"sn"`
+	Time time.Time `json:"time"`
+
+	// event payload, e.g. AttendanceLog, OperationLog,
+	// DeviceStatus or CommandRecord
+	Data interface{} `json:"data"`
+}
+
+// EventFilter select events delivered to subscriber.
+// empty field match all
+type EventFilter struct {
+	SN    []string
+	Types []string
+}
+
+// match check whether event pass the filter
+func (f EventFilter) match(e Event) bool {
+	return matchAny(f.SN, e.SN) && matchAny(f.Types, e.Type)
+}
+
+// matchAny check whether v listed, empty list match all
+func matchAny(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscription receive published events which pass its filter
+type Subscription struct {
+	bus    *EventBus
+	filter EventFilter
+
+	// lossless subscriber block publisher instead of
+	// dropping events, until it closed
+	block bool
+	done  chan struct{}
+	once  sync.Once
+
+	// guard channel from being closed while delivering
+	mu     sync.RWMutex
+	closed bool
+
+	// events channel, closed when unsubscribed
+	// or event bus closed
+	C chan Event
+}
+
+// Close stop receiving events
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// close release blocked publisher, then close events channel
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.C)
+	}
+}
+
+// deliver send event unless subscription closed, lossless
+// subscriber wait until event received
+func (s *Subscription) deliver(e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	if s.block {
+		select {
+		case s.C <- e:
+		case <-s.done:
+		}
+		return
+	}
+
+	select {
+	case s.C <- e:
+	default:
+		log.Printf("event %s of %s dropped, subscriber too slow\n", e.Type, e.SN)
+	}
+}
+
+// EventBus fan out published events to subscribers.
+// slow subscriber doesn't block publisher, events dropped
+// when its buffer full, unless subscribed as lossless
+type EventBus struct {
+	sync.RWMutex
+
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewEventBus create event bus without subscriber
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe register subscriber with given filter and buffer size
+func (b *EventBus) Subscribe(filter EventFilter, buffer int) *Subscription {
+	return b.subscribe(filter, buffer, false)
+}
+
+// SubscribeLossless register subscriber which never miss event,
+// publisher wait while its buffer full. subscriber must keep
+// receiving until closed, otherwise device upload stalled
+func (b *EventBus) SubscribeLossless(filter EventFilter, buffer int) *Subscription {
+	return b.subscribe(filter, buffer, true)
+}
+
+// subscribe register subscriber, lossless subscriber must
+// keep receiving until closed
+func (b *EventBus) subscribe(filter EventFilter, buffer int, block bool) *Subscription {
+	if buffer < 1 {
+		buffer = defaultEventBuffer
+	}
+
+	sub := &Subscription{
+		bus:    b,
+		filter: filter,
+		block:  block,
+		done:   make(chan struct{}),
+		C:      make(chan Event, buffer),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		sub.close()
+		return sub
+	}
+
+	b.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (b *EventBus) unsubscribe(sub *Subscription) {
+	b.Lock()
+	delete(b.subscribers, sub)
+	b.Unlock()
+
+	sub.close()
+}
+
+// Publish deliver event to matching subscribers
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	// deliver outside lock, so lossless subscriber
+	// doesn't hold subscribe and unsubscribe
+	b.RLock()
+	subs := make([]*Subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		if sub.filter.match(e) {
+			subs = append(subs, sub)
+		}
+	}
+	b.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(e)
+	}
+}
+
+// Close unsubscribe all subscribers, so
+// streaming consumers can finish
+func (b *EventBus) Close() {
+	b.Lock()
+	subs := b.subscribers
+	b.subscribers = make(map[*Subscription]struct{})
+	b.closed = true
+	b.Unlock()
+
+	for sub := range subs {
+		sub.close()
+	}
+}
+
+// Events return event bus of server
+func (s *Server) Events() *EventBus {
+	return s.events
+}
+
+// publish event of device
+func (s *Server) publish(eventType string, sn string, data interface{}) {
+	s.events.Publish(Event{
+		Type: eventType,
+		SN:   sn,
+		Data: data,
+	})
+}
@@ -0,0 +1,188 @@
+package push
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttendanceLogUnmarshall(t *testing.T) {
+	var record AttendanceLog
+	if err := Unmarshall([]byte("12\t2019-05-20 08:30:10\t0\t1\t\t0\t0"), &record); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if record.PIN != "12" || record.Verify != 1 || !record.Time.Equal(time.Date(2019, 5, 20, 8, 30, 10, 0, time.Local)) {
+		t.Errorf("unexpected attendance log %+v", record)
+		t.FailNow()
+	}
+
+	var oplog OperationLog
+	if err := Unmarshall([]byte("OPLOG 4\t0\t2019-05-20 08:00:00\t12\t0\t0\t0"), &oplog); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if oplog.Type != 4 || oplog.Operator != "0" || len(oplog.Values) != 4 || oplog.Values[0] != "12" {
+		t.Errorf("unexpected operation log %+v", oplog)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("1")
+	s.RegisterDevice("2")
+
+	sub := s.Events().Subscribe(EventFilter{SN: []string{"2"}, Types: []string{EventAttendance}}, 0)
+	defer sub.Close()
+
+	for _, sn := range []string{"1", "2"} {
+		w := httptest.NewRecorder()
+		s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN="+sn+"&table=ATTLOG&Stamp=1", strings.NewReader("12\t2019-05-20 08:30:10\t0\t1\n")))
+	}
+
+	select {
+	case e := <-sub.C:
+		record, ok := e.Data.(AttendanceLog)
+		if e.SN != "2" || !ok || record.PIN != "12" {
+			t.Errorf("unexpected event %+v", e)
+			t.FailNow()
+		}
+	default:
+		t.Error("expected attendance event")
+		t.FailNow()
+	}
+
+	select {
+	case e := <-sub.C:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestEventDataFields(t *testing.T) {
+	status := DeviceStatus{
+		Device: Device{SN: "123456789"},
+		Info:   DeviceInfo{FirmwareVersion: "Ver 6.60"},
+	}
+
+	b, err := json.Marshal(Event{Type: EventDeviceOnline, SN: status.SN, Data: status})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// field names follow snake case like rest of api
+	for _, field := range []string{`"sn":"123456789"`, `"firmware_version":"Ver 6.60"`, `"last_seen":`} {
+		if !strings.Contains(string(b), field) {
+			t.Errorf("expected %s on %s", field, b)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	srv := httptest.NewServer(s.AdminHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?sn=123456789&type=attendance")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+
+	s.publish(EventAttendance, "123456789", AttendanceLog{PIN: "12"})
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var e struct {
+			Type string
+			SN   string
+			Data AttendanceLog
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if e.Type != EventAttendance || e.Data.PIN != "12" {
+			t.Errorf("unexpected event %+v", e)
+		}
+
+		return
+	}
+}
+
+func TestEventLosslessSubscriber(t *testing.T) {
+	bus := NewEventBus()
+
+	sub := bus.SubscribeLossless(EventFilter{}, 1)
+
+	published := make(chan struct{})
+	go func() {
+		// second event wait for lossless subscriber
+		bus.Publish(Event{Type: EventAttendance, SN: "1"})
+		bus.Publish(Event{Type: EventAttendance, SN: "2"})
+		close(published)
+	}()
+
+	// bus not held by blocked publisher
+	subscribed := make(chan *Subscription)
+	go func() {
+		other := bus.Subscribe(EventFilter{}, 0)
+		other.Close()
+		subscribed <- other
+	}()
+
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second * 5):
+		t.Error("expected subscribe not blocked by lossless subscriber")
+		t.FailNow()
+	}
+
+	for _, sn := range []string{"1", "2"} {
+		if e := <-sub.C; e.SN != sn {
+			t.Errorf("expected event of %s but returned %+v", sn, e)
+			t.FailNow()
+		}
+	}
+
+	<-published
+
+	// closed subscriber release publisher
+	bus.Publish(Event{Type: EventAttendance, SN: "3"})
+
+	released := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: EventAttendance, SN: "4"})
+		close(released)
+	}()
+
+	sub.Close()
+
+	select {
+	case <-released:
+	case <-time.After(time.Second * 5):
+		t.Error("expected publisher released by closed subscriber")
+	}
+}
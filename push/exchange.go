@@ -14,10 +14,15 @@ type ExchangeCommand struct {
 	TransTimes    string
 	TransInterval int
 	TransFlag     string
-	TimeZone      int
+	TimeZone      int // hours, or minutes when not whole hour
 	Realtime      int
 	Encrypt       int
 	ServerVer     string
+
+	// location of device clock, e.g. Asia/Jakarta or UTC+7, used to
+	// interpret device timestamps. not sent to device, TimeZone
+	// derived from it when zero. empty means DeviceLocation option
+	Location string
 }
 
 // Marshall implement payload.Marshall interface
@@ -42,7 +47,11 @@ func (c ExchangeCommand) Marshall() ([]byte, error) {
 	writeStringValue(buf, "ServerVer", lf, c.ServerVer)
 	writeIntValue(buf, "Encrypt", lf, c.Encrypt, true)
 
-	return buf.Bytes(), nil
+	// copy, as buffer returned to pool
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+
+	return b, nil
 }
 
 // Unmarshall implement payload.Unmarshall interface
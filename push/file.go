@@ -0,0 +1,244 @@
+package push
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// file transfer related error
+var (
+	ErrPublicURLRequired = errors.New("Public url is required to serve file to device")
+	ErrFileSizeMismatch  = errors.New("File data doesn't match the given size")
+	ErrEmptyFilePath     = errors.New("File path is empty")
+	ErrInvalidFilePath   = errors.New("File path must not contain tab or line break")
+)
+
+// event type of command progress, e.g. file downloaded by device
+const EventCommandProgress = "command_progress"
+
+// path of file download endpoint, followed by token
+const filePath = "/iclock/file/"
+
+// file served to device on PutFile or firmware upgrade
+type fileTransfer struct {
+	SN        string
+	CommandID string
+	Content   []byte
+}
+
+// fileTokens hold files waiting to be downloaded by device,
+// each token can be downloaded once
+type fileTokens struct {
+	sync.Mutex
+
+	files map[string]fileTransfer
+}
+
+// add register file and return its token
+func (t *fileTokens) add(file fileTransfer) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(b)
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.files == nil {
+		t.files = make(map[string]fileTransfer)
+	}
+	t.files[token] = file
+
+	return token, nil
+}
+
+// take remove and return file of token
+func (t *fileTokens) take(token string) (fileTransfer, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	file, ok := t.files[token]
+	delete(t.files, token)
+
+	return file, ok
+}
+
+// validFilePath return error when path can't be sent on file command,
+// separator would inject another field or command
+func validFilePath(path string) error {
+	if path == "" {
+		return ErrEmptyFilePath
+	}
+
+	if strings.ContainsAny(path, "\t\r\n") {
+		return ErrInvalidFilePath
+	}
+
+	return nil
+}
+
+// GetFileCommand create command which request device
+// to upload file content on command result
+func GetFileCommand(path string) (Command, error) {
+	if err := validFilePath(path); err != nil {
+		return Command{}, err
+	}
+
+	return Command{CMD: "GetFile", Payload: []byte(path)}, nil
+}
+
+// PutFileCommand create command which request device
+// to download file from url and save it on given path
+func PutFileCommand(url string, path string) (Command, error) {
+	if err := validFilePath(path); err != nil {
+		return Command{}, err
+	}
+
+	return Command{CMD: "PutFile", Payload: []byte(url + "\t" + path)}, nil
+}
+
+// UpgradeCommand create command which request device to download
+// firmware from url, verify its md5 checksum and size, then upgrade
+func UpgradeCommand(url string, checksum string, size int) Command {
+	return Command{
+		CMD:     "UPGRADE",
+		Payload: []byte(fmt.Sprintf("checksum=%s,url=%s,size=%d", checksum, url, size)),
+	}
+}
+
+// fileURL return address of token on public url of server
+func (s *Server) fileURL(token string) (string, error) {
+	base := strings.TrimSuffix(s.option.PublicURL, "/")
+	if base == "" {
+		return "", ErrPublicURLRequired
+	}
+
+	return base + strings.TrimSuffix(s.option.PathPrefix, "/") + filePath + token, nil
+}
+
+// serveFile send command which download content from one time
+// token url. token dropped once command completed
+func (s *Server) serveFile(ctx context.Context, sn string, content []byte, command func(url string) Command) error {
+	id := randomCommandID()
+
+	token, err := s.files.add(fileTransfer{SN: sn, CommandID: id, Content: content})
+	if err != nil {
+		return err
+	}
+	defer s.files.take(token)
+
+	url, err := s.fileURL(token)
+	if err != nil {
+		return err
+	}
+
+	cmd := command(url)
+	cmd.ID = id
+
+	resp, err := s.do(ctx, sn, cmd)
+	if err != nil {
+		return err
+	}
+
+	return resp.Err()
+}
+
+// GetFile request device to upload file on given path
+func (s *Server) GetFile(ctx context.Context, sn string, path string) ([]byte, error) {
+	cmd, err := GetFileCommand(path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.DoContext(ctx, sn, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resp.Err(); err != nil {
+		return nil, err
+	}
+
+	// content encoded as base64, older firmware send raw bytes
+	content, err := base64.StdEncoding.DecodeString(string(resp.Payload))
+	if err != nil {
+		content = resp.Payload
+	}
+
+	// return code of succeed GetFile is file size
+	if len(content) != resp.Return {
+		return nil, ErrFileSizeMismatch
+	}
+
+	return content, nil
+}
+
+// PutFile request device to download content and save it on given
+// path. file served by this server through one time token, so
+// PublicURL must be reachable by device. when state shared,
+// PublicURL should address this replica
+func (s *Server) PutFile(ctx context.Context, sn string, path string, content []byte) error {
+	// validate before file served
+	if err := validFilePath(path); err != nil {
+		return err
+	}
+
+	return s.serveFile(ctx, sn, content, func(url string) Command {
+		cmd, _ := PutFileCommand(url, path)
+		return cmd
+	})
+}
+
+// UpgradeFirmware request device to download and install firmware,
+// served the same way as PutFile. device may restart before
+// reporting result, in which case command end as unacknowledged
+func (s *Server) UpgradeFirmware(ctx context.Context, sn string, firmware []byte) error {
+	if len(firmware) == 0 {
+		return ErrEmptyPayload
+	}
+
+	sum := md5.Sum(firmware)
+
+	return s.serveFile(ctx, sn, firmware, func(url string) Command {
+		return UpgradeCommand(url, hex.EncodeToString(sum[:]), len(firmware))
+	})
+}
+
+// handleFile serve file of one time token, download
+// reported as progress of corresponding command
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.files.take(mux.Vars(r)["token"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(file.Content)))
+	w.WriteHeader(http.StatusOK)
+
+	n, err := w.Write(file.Content)
+	if err != nil {
+		log.Printf("failed to send file to %s: %v\n", file.SN, err)
+	}
+
+	record := s.recordCommand(file.CommandID, func(record *CommandRecord) {
+		record.Transferred = n
+	})
+
+	s.publish(EventCommandProgress, file.SN, record)
+}
@@ -0,0 +1,182 @@
+package push
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutFile(t *testing.T) {
+	s := NewServer(&ServerOption{PublicURL: "http://zk.local/"})
+	s.RegisterDevice("123456789")
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		done <- s.PutFile(ctx, "123456789", "/mnt/mtdblock/data/logo.jpg", []byte("image"))
+	}()
+
+	polled := pollCommand(s, "123456789")
+
+	fields := strings.SplitN(strings.TrimSpace(polled), " ", 2)
+	if len(fields) != 2 || !strings.HasSuffix(fields[0], ":PutFile") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	args := strings.Split(fields[1], "\t")
+	if len(args) != 2 || !strings.HasPrefix(args[0], "http://zk.local/iclock/file/") || args[1] != "/mnt/mtdblock/data/logo.jpg" {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	path := strings.TrimPrefix(args[0], "http://zk.local")
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "image" {
+		t.Errorf("unexpected file response %d %s", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	// token valid once
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected not found on second download but returned %d", w.Code)
+	}
+
+	id := strings.SplitN(polled, ":", 3)[1]
+	if record, ok := s.history.Get(id); !ok || record.Transferred != 5 {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// return code of succeed PutFile is file size
+	respondCommand(s, "123456789", polled, "5")
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileCommandInvalidPath(t *testing.T) {
+	s := NewServer(&ServerOption{PublicURL: "http://zk.local/"})
+	s.RegisterDevice("123456789")
+
+	// path inject another command
+	if err := s.PutFile(context.Background(), "123456789", "logo.jpg\nC:1:CLEAR DATA", []byte("image")); err != ErrInvalidFilePath {
+		t.Errorf("expected invalid file path but returned %v", err)
+		t.FailNow()
+	}
+
+	if _, err := GetFileCommand("options.cfg\tx"); err != ErrInvalidFilePath {
+		t.Errorf("expected invalid file path but returned %v", err)
+		t.FailNow()
+	}
+
+	if cmds, _ := s.getCommandQueue("123456789"); len(cmds) != 0 {
+		t.Errorf("expected nothing queued but returned %v", cmds)
+	}
+}
+
+func TestPutFileWithoutPublicURL(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	if err := s.PutFile(context.Background(), "123456789", "logo.jpg", []byte("image")); err != ErrPublicURLRequired {
+		t.Errorf("expected public url required but returned %v", err)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	type result struct {
+		content []byte
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		content, err := s.GetFile(context.Background(), "123456789", "/mnt/mtdblock/options.cfg")
+		done <- result{content, err}
+	}()
+
+	polled := pollCommand(s, "123456789")
+	if !strings.HasSuffix(polled, ":GetFile /mnt/mtdblock/options.cfg\n") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	content := "Delay=30\nLockOn=5\n"
+	body := "ID=" + strings.SplitN(polled, ":", 3)[1] + "&SN=123456789&FILENAME=/mnt/mtdblock/options.cfg&CMD=GetFile&Return=18&Content=" +
+		base64.StdEncoding.EncodeToString([]byte(content))
+
+	w := httptest.NewRecorder()
+	s.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN=123456789", strings.NewReader(body)))
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if string(r.content) != content {
+		t.Errorf("expected %q but returned %q", content, r.content)
+	}
+}
+
+func TestGetFileMultiline(t *testing.T) {
+	content := strings.Repeat("Delay=30\r\nLockOn=5\n", 10)
+
+	// mime base64 wrap encoded content every 76 characters
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	var wrapped []string
+	for len(encoded) > 76 {
+		wrapped = append(wrapped, encoded[:76])
+		encoded = encoded[76:]
+	}
+	wrapped = append(wrapped, encoded)
+
+	testCases := []struct {
+		name    string
+		payload string
+	}{
+		{"raw", content},
+		{"mime", strings.Join(wrapped, "\r\n")},
+	}
+
+	for _, tc := range testCases {
+		s := NewServer(&ServerOption{})
+		s.RegisterDevice("123456789")
+
+		done := make(chan []byte, 1)
+		go func() {
+			content, err := s.GetFile(context.Background(), "123456789", "/mnt/mtdblock/options.cfg")
+			if err != nil {
+				t.Error(err)
+			}
+			done <- content
+		}()
+
+		polled := pollCommand(s, "123456789")
+		body := "ID=" + strings.SplitN(polled, ":", 3)[1] + "&SN=123456789&FILENAME=/mnt/mtdblock/options.cfg&CMD=GetFile&Return=" +
+			strconv.Itoa(len(content)) + "&Content=" + tc.payload
+
+		w := httptest.NewRecorder()
+		s.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN=123456789", strings.NewReader(body)))
+
+		if r := <-done; string(r) != content {
+			t.Errorf("expected %s content %q but returned %q", tc.name, content, r)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrInvalidFingerIndex returned when finger index out of 0-9
+var ErrInvalidFingerIndex = errors.New("Finger index must be between 0 and 9")
+
+// event type of uploaded finger print template
+const EventFingerPrint = "fingerprint"
+
+// record prefix of finger print template on operation log upload
+var fingerPrintPrefix = []byte("FP ")
+
+// FingerPrintHook define callback upon receiving
+// finger print template uploaded by device
+type FingerPrintHook interface {
+	OnFingerPrint(sn string, fp FingerPrint)
+}
+
+// FingerPrint represent finger print template of user
+type FingerPrint struct {
+	PIN string `json:"pin"`
+
+	// finger index, 0-9
+	FID int `json:"fid"`
+
+	Valid int `json:"valid"`
+
+	// raw template
+	Template []byte `json:"template"`
+}
+
+// Unmarshall implement payload.Unmarshall interface
+func (f *FingerPrint) Unmarshall(b []byte) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(bytes.TrimPrefix(b, fingerPrintPrefix), "\t")
+
+	content := fields["TMP"]
+	if size, err := strconv.Atoi(fields["Size"]); err == nil && size != len(content) {
+		return ErrTemplateSizeMismatch
+	}
+
+	template, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return err
+	}
+
+	f.PIN = fields["PIN"]
+	f.FID = value(fields["FID"]).ToInt(0)
+	f.Valid = value(fields["Valid"]).ToInt(0)
+	f.Template = template
+
+	return nil
+}
+
+// Marshall implement payload.Marshall interface
+func (f FingerPrint) Marshall() ([]byte, error) {
+	content := base64.StdEncoding.EncodeToString(f.Template)
+
+	return []byte(fmt.Sprintf("PIN=%s\tFID=%d\tSize=%d\tValid=%d\tTMP=%s", f.PIN, f.FID, len(content), f.Valid, content)), nil
+}
+
+// EnrollFingerPrintCommand create command which start finger print
+// enrollment on device, user must place finger on reader
+func EnrollFingerPrintCommand(pin string, fid int, retries int) (Command, error) {
+	if fid < 0 || fid > 9 {
+		return Command{}, ErrInvalidFingerIndex
+	}
+
+	if !validPIN(pin) {
+		return Command{}, ErrInvalidPIN
+	}
+
+	return Command{
+		CMD:     "ENROLL_FP",
+		Payload: []byte(fmt.Sprintf("PIN=%s\tFID=%d\tRETRY=%d\tOVERWRITE=0", pin, fid, retries)),
+	}, nil
+}
+
+func (s *Server) uploadFingerPrint(sn string, line []byte) error {
+	var fp FingerPrint
+	if err := Unmarshall(line, &fp); err != nil {
+		return err
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(FingerPrintHook); ok {
+			hook.OnFingerPrint(sn, fp)
+		}
+	}
+
+	s.publish(EventFingerPrint, sn, fp)
+
+	return nil
+}
+
+// EnrollFingerprint start remote finger print enrollment, wait until
+// device report the result and upload enrolled template. enrollment
+// failure returned as typed error, e.g. ErrFingerPrintExists.
+// template upload observed on this server's event bus, so device
+// should poll same replica when state shared
+func (s *Server) EnrollFingerprint(ctx context.Context, sn string, pin string, fingerIndex int, retries int) (FingerPrint, error) {
+	cmd, err := EnrollFingerPrintCommand(pin, fingerIndex, retries)
+	if err != nil {
+		return FingerPrint{}, err
+	}
+
+	// user waiting in front of reader, prompt
+	// never started after caller gave up
+	cmd.Priority = PriorityUrgent
+	if deadline, ok := ctx.Deadline(); ok {
+		cmd.ExpireAt = deadline
+	}
+
+	// subscribe before sending, template may be uploaded
+	// ahead of command result
+	sub := s.events.Subscribe(EventFilter{SN: []string{sn}, Types: []string{EventFingerPrint}}, 0)
+	defer sub.Close()
+
+	resp, err := s.DoContext(ctx, sn, cmd)
+	if err != nil {
+		return FingerPrint{}, err
+	}
+
+	if err := resp.Err(); err != nil {
+		return FingerPrint{}, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return FingerPrint{}, ctx.Err()
+		case e, ok := <-sub.C:
+			if !ok {
+				return FingerPrint{}, context.Canceled
+			}
+
+			if fp, ok := e.Data.(FingerPrint); ok && fp.PIN == pin && fp.FID == fingerIndex {
+				return fp, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pollCommand poll device commands until command available
+func pollCommand(s *Server, sn string) string {
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		s.handleCommand(w, httptest.NewRequest("GET", "/iclock/getrequest?SN="+sn, nil))
+		if body := w.Body.String(); body != "OK" {
+			return body
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return ""
+}
+
+// respondCommand post command result of polled command,
+// followed by result payload lines if any
+func respondCommand(s *Server, sn string, polled string, code string, payload ...string) {
+	id := strings.SplitN(polled, ":", 3)[1]
+	cmd := strings.SplitN(strings.SplitN(polled, ":", 3)[2], " ", 2)[0]
+	if strings.Contains(polled, ":GET OPTION ") {
+		cmd = "GET OPTION"
+	}
+
+	body := "ID=" + id + "&Return=" + code + "&CMD=" + cmd
+	for _, line := range payload {
+		body += "\n" + line
+	}
+
+	w := httptest.NewRecorder()
+	s.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN="+sn, strings.NewReader(body)))
+}
+
+func TestEnrollFingerprint(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	template := []byte{0x01, 0x02, 0x03}
+
+	type result struct {
+		fp  FingerPrint
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		fp, err := s.EnrollFingerprint(ctx, "123456789", "12", 6, 3)
+		done <- result{fp, err}
+	}()
+
+	polled := pollCommand(s, "123456789")
+	if !strings.Contains(polled, "ENROLL_FP PIN=12\tFID=6\tRETRY=3") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	// device upload template then report result
+	line, _ := FingerPrint{PIN: "12", FID: 6, Valid: 1, Template: template}.Marshall()
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=OPERLOG", bytes.NewReader(append([]byte("FP "), line...))))
+
+	respondCommand(s, "123456789", polled, "0")
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if r.fp.PIN != "12" || r.fp.FID != 6 || !bytes.Equal(r.fp.Template, template) {
+		t.Errorf("unexpected template %+v", r.fp)
+	}
+}
+
+func TestEnrollFingerprintExists(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.EnrollFingerprint(context.Background(), "123456789", "12", 6, 3)
+		done <- err
+	}()
+
+	respondCommand(s, "123456789", pollCommand(s, "123456789"), "2")
+
+	if err := <-done; err != ErrFingerPrintExists {
+		t.Errorf("expected finger print exists but returned %v", err)
+	}
+}
+
+func TestEnrollFingerprintExpired(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := s.EnrollFingerprint(ctx, "123456789", "12", 6, 3); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded but returned %v", err)
+		t.FailNow()
+	}
+
+	// device poll after caller gave up
+	w := httptest.NewRecorder()
+	s.handleCommand(w, httptest.NewRequest("GET", "/iclock/getrequest?SN=123456789", nil))
+
+	if body := w.Body.String(); body != "OK" {
+		t.Errorf("expected enrollment expired but returned %s", body)
+	}
+}
+
+func TestEnrollFingerPrintCommandInvalid(t *testing.T) {
+	if _, err := EnrollFingerPrintCommand("12", 10, 3); err != ErrInvalidFingerIndex {
+		t.Errorf("expected invalid finger index but returned %v", err)
+		t.FailNow()
+	}
+
+	// pin inject another command
+	for _, pin := range []string{"", "12\nC:1:CLEAR DATA", "12\tFID=1"} {
+		if _, err := EnrollFingerPrintCommand(pin, 6, 3); err != ErrInvalidPIN {
+			t.Errorf("expected invalid pin of %q but returned %v", pin, err)
+		}
+	}
+}
@@ -1,19 +1,19 @@
 package push
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (s *Server) handleExchange(w http.ResponseWriter, r *http.Request) {
-	// parse device info
-	var device Device
-	if err := Unmarshall([]byte(r.URL.RawQuery), &device); err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
-	}
-
+// exchange call exchange hook and register allowed device.
+// return nil if device not allowed
+func (s *Server) exchange(device Device) *ExchangeCommand {
 	// check whether device is allowed to be connected
 	var cmd *ExchangeCommand
 
@@ -25,15 +25,43 @@ func (s *Server) handleExchange(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if cmd == nil {
-		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-		return
+		return nil
 	}
 
 	// put on registered devices, if not exists
-	if _, ok := s.deviceCommands.Load(device.SN); !ok {
+	if _, err := s.getDeviceQueue(device.SN); err != nil {
 		s.RegisterDevice(device.SN)
 	}
 
+	// keep device info for presence tracking
+	s.registry.Update(device, cmd.Delay)
+
+	// interpret timestamps written by device clock
+	s.applyLocation(device.SN, cmd)
+
+	// resume upload from last recorded stamps
+	s.fillStamps(device.SN, cmd)
+
+	// decide payload encryption for further communication
+	s.negotiateEncryption(device, cmd)
+
+	return cmd
+}
+
+func (s *Server) handleExchange(w http.ResponseWriter, r *http.Request) {
+	// parse device info
+	var device Device
+	if err := Unmarshall([]byte(r.URL.RawQuery), &device); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	cmd := s.exchange(device)
+	if cmd == nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
 	// send exchange result
 	b, err := cmd.Marshall()
 	if err != nil {
@@ -52,8 +80,27 @@ func (s *Server) handleExchange(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
 	log.Println("get info")
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	query := r.URL.Query()
+	sn := query.Get("SN")
+
+	var info DeviceInfo
+	if err := Unmarshall([]byte(query.Get("INFO")), &info); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	s.registry.SetInfo(sn, info)
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(InfoHook); ok {
+			hook.OnDeviceInfo(sn, info)
+		}
+	}
+
+	// info is sent along with command request,
+	// so answer with queued commands
+	s.handleCommand(w, r)
 }
 
 func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
@@ -62,7 +109,7 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 	sn := r.URL.Query().Get("SN")
 
 	// get command queued to target device
-	queue, err := s.getCommandQueue(sn)
+	queue, err := s.pollCommandQueue(sn)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
@@ -83,9 +130,6 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Write([]byte("OK"))
 	}
-
-	// flush command
-	s.flushCommandQueue(sn)
 }
 
 func (s *Server) handleCommandResponse(w http.ResponseWriter, r *http.Request) {
@@ -98,24 +142,58 @@ func (s *Server) handleCommandResponse(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	var response CommandResponse
-	if err := Unmarshall(body, &response); err != nil {
+	responses, err := splitCommandResponses(body)
+	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	// get registered callback
-	cmd, err := s.getCommandCallback(response.ID)
-	if err == nil {
-
-		// trigger callback
-		cmd.Callback(response)
+	sn := r.URL.Query().Get("SN")
+	for _, response := range responses {
+		s.completeCommand(sn, response)
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// completeCommand record result reported by device and
+// trigger callback of issuer
+func (s *Server) completeCommand(sn string, response CommandResponse) {
+	// command delivered, stop re-delivery
+	s.ackCommand(sn, response.ID)
+
+	record := s.recordCommand(response.ID, func(record *CommandRecord) {
+		if record.SN == "" {
+			record.SN = sn
+			record.CMD = response.CMD
+		}
+
+		record.Status = CommandSucceeded
+		if err := response.Err(); err != nil {
+			record.Status = CommandFailed
+			record.Error = err.Error()
+		}
+
+		record.Return = response.Return
+		record.Response = string(response.Payload)
+		record.CompletedAt = time.Now()
+	})
+
+	s.publish(EventCommandResult, sn, record)
+
+	// get registered callback
+	if cmd, ok := s.takeCommandCallback(response.ID); ok {
+		// trigger callback
+		if cmd.Callback != nil {
+			cmd.Callback(response)
+		}
+	} else if s.option.SharedState != nil {
+		// callback may be held by other replica
+		s.routeResult(sharedResult{ID: response.ID, Response: &response})
+	}
+}
+
 func (s *Server) handleCatchAll(w http.ResponseWriter, r *http.Request) {
 	log.Println("Method:", r.Method, r.URL.Path)
 	log.Println("not found")
@@ -123,3 +201,288 @@ func (s *Server) handleCatchAll(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+func (s *Server) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// device parameters sent as comma separated key value
+	options := parseOptions(body, ",")
+
+	device := Device{
+		SN:          query.Get("SN"),
+		Option:      string(body),
+		PushVersion: options["PushVersion"],
+		PushCommKey: query.Get("pushcommkey"),
+	}
+	device.Capabilities = ParseCapabilities(device.Option, DeviceInfo{})
+
+	if protocolVersion(device.PushVersion) < registryProtocolVersion {
+		device.PushVersion = strconv.Itoa(registryProtocolVersion)
+	}
+
+	cmd := s.exchange(device)
+	if cmd == nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	sess := s.sessions.open(device.SN, *cmd)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("RegistryCode=" + sess.RegistryCode))
+}
+
+func (s *Server) handlePushConfig(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.sessions.get(r.URL.Query().Get("SN"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(marshallPushConfig(sess.Config, sess.ID))
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (s *Server) handleQueryData(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	data := QueryData{
+		Type:        query.Get("type"),
+		CommandID:   query.Get("cmdid"),
+		Table:       query.Get("tablename"),
+		Count:       value(query.Get("count")).ToInt(),
+		PacketCount: value(query.Get("packcnt")).ToInt(),
+		PacketIndex: value(query.Get("packidx")).ToInt(),
+	}
+	data.Records = parseQueryRecords(data.Table, body)
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(QueryDataHook); ok {
+			hook.OnQueryData(query.Get("SN"), data)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("%s=%d", data.Table, len(data.Records))))
+}
+
+func (s *Server) handleRealtimeData(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("type") != "time" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
+
+	// device time follow location of its clock
+	now := time.Now().In(s.location(query.Get("SN")))
+	_, offset := now.Zone()
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("DateTime=%d,ServerTZ=%s", encodeDeviceTime(now), formatTimeZone(offset))))
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sn := query.Get("SN")
+	table := strings.ToUpper(query.Get("table"))
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	switch table {
+	case "ATTLOG":
+		err = s.uploadAttendanceLog(sn, body)
+	case "ATTPHOTO":
+		err = s.uploadAttendancePhoto(sn, body)
+	case "OPERLOG", "USERPIC", "BIOPHOTO":
+		err = s.uploadOperationLog(sn, body)
+	case "RTLOG":
+		err = s.uploadAccessEvents(sn, body)
+	case "RTSTATE":
+		err = s.uploadDoorStates(sn, body)
+	default:
+		log.Printf("unhandled upload table %s from %s\n", table, sn)
+	}
+
+	if err != nil {
+		log.Printf("failed to process %s upload from %s: %v\n", table, sn, err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	// only stored attendance logs confirm upload, realtime events
+	// of access control panel don't mean its logs were collected
+	if table == "ATTLOG" {
+		s.registry.Uploaded(sn, time.Now())
+	}
+
+	// record stamp, so upload resumed from here after restart
+	if stamp, ok := uploadStamp(query); ok {
+		if err := s.stamps.Set(sn, table, stamp); err != nil {
+			log.Printf("failed to record %s stamp of %s: %v\n", table, sn, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (s *Server) uploadAttendancePhoto(sn string, body []byte) error {
+	photo := AttendancePhoto{SN: sn}
+	if err := photo.unmarshallIn(body, s.location(sn)); err != nil {
+		return err
+	}
+
+	if s.option.PhotoStore != nil {
+		if err := s.option.PhotoStore.Save(photo); err != nil {
+			return err
+		}
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(PhotoHook); ok {
+			hook.OnAttendancePhoto(photo)
+		}
+	}
+
+	return nil
+}
+
+// uploadOperationLog process operation log records, one per line.
+// invalid record skipped, others still delivered
+func (s *Server) uploadOperationLog(sn string, body []byte) error {
+	for _, line := range bytes.Split(body, lf) {
+		line = bytes.TrimSpace(line)
+
+		var err error
+		switch {
+		case len(line) == 0:
+			continue
+		case bytes.HasPrefix(line, operationLogPrefix):
+			err = s.uploadOperationRecord(sn, line)
+		case bytes.HasPrefix(line, userPrefix):
+			err = s.uploadUser(sn, line)
+		case bytes.HasPrefix(line, fingerPrintPrefix):
+			err = s.uploadFingerPrint(sn, line)
+		case bytes.HasPrefix(line, userPhotoPrefix):
+			err = s.uploadUserPhoto(sn, line)
+		case bytes.HasPrefix(line, bioPhotoPrefix):
+			err = s.uploadBioPhoto(sn, line)
+		}
+
+		// skipped, so records before it aren't delivered
+		// again when device re-send whole batch
+		if err != nil {
+			log.Printf("skipped invalid operation record of %s: %v\n", sn, err)
+		}
+	}
+
+	return nil
+}
+
+// uploadAttendanceLog process attendance records, one per line.
+// invalid record skipped, others still delivered
+func (s *Server) uploadAttendanceLog(sn string, body []byte) error {
+	loc := s.location(sn)
+
+	for _, line := range bytes.Split(body, lf) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var record AttendanceLog
+		if err := record.unmarshallIn(line, loc); err != nil {
+			log.Printf("skipped invalid attendance record of %s: %v\n", sn, err)
+			continue
+		}
+
+		// call hook
+		if s.hook != nil {
+			if hook, ok := s.hook.(AttendanceHook); ok {
+				hook.OnAttendanceLog(sn, record)
+			}
+		}
+
+		s.publish(EventAttendance, sn, record)
+	}
+
+	return nil
+}
+
+func (s *Server) uploadOperationRecord(sn string, line []byte) error {
+	var record OperationLog
+	if err := record.unmarshallIn(line, s.location(sn)); err != nil {
+		return err
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(AttendanceHook); ok {
+			hook.OnOperationLog(sn, record)
+		}
+	}
+
+	s.publish(EventOperationLog, sn, record)
+
+	return nil
+}
+
+func (s *Server) uploadUserPhoto(sn string, line []byte) error {
+	var photo UserPhoto
+	if err := Unmarshall(line, &photo); err != nil {
+		return err
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(UserPhotoHook); ok {
+			hook.OnUserPhoto(sn, photo)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) uploadBioPhoto(sn string, line []byte) error {
+	var photo BioPhoto
+	if err := Unmarshall(line, &photo); err != nil {
+		return err
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(UserPhotoHook); ok {
+			hook.OnBioPhoto(sn, photo)
+		}
+	}
+
+	return nil
+}
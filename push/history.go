@@ -0,0 +1,165 @@
+package push
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// default number of records kept by in memory history
+const defaultHistorySize = 1000
+
+// command status
+const (
+	CommandQueued    = "queued"
+	CommandSent      = "sent"
+	CommandSucceeded = "succeeded"
+	CommandFailed    = "failed"
+
+	// scheduled command not queued, e.g. device offline
+	CommandSkipped = "skipped"
+)
+
+// CommandRecord describe lifecycle of single command
+type CommandRecord struct {
+	ID       string          `json:"id"`
+	SN       string          `json:"sn"`
+	CMD      string          `json:"cmd"`
+	Payload  string          `json:"payload,omitempty"`
+	Priority CommandPriority `json:"priority"`
+
+	// id of schedule which issue command
+	Schedule string `json:"schedule,omitempty"`
+
+	// see const command status
+	Status string `json:"status"`
+
+	// number of times command sent to device
+	Attempts int `json:"attempts"`
+
+	// number of bytes downloaded by device
+	// on file transfer or firmware upgrade
+	Transferred int `json:"transferred,omitempty"`
+
+	// return code and payload of command result
+	Return   int    `json:"return"`
+	Response string `json:"response,omitempty"`
+
+	// reason when command failed
+	Error string `json:"error,omitempty"`
+
+	QueuedAt    time.Time `json:"queued_at"`
+	SentAt      time.Time `json:"sent_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+}
+
+// CommandHistory persist command records
+type CommandHistory interface {
+	// Save insert or replace record with same id
+	Save(record CommandRecord) error
+
+	// Get return record by command id
+	Get(id string) (CommandRecord, bool)
+
+	// List return latest records of device, newest first.
+	// empty sn means all devices
+	List(sn string, limit int) []CommandRecord
+}
+
+// InMemoryCommandHistory implement CommandHistory using memory.
+// oldest records dropped when size exceeded
+type InMemoryCommandHistory struct {
+	sync.RWMutex
+
+	size    int
+	order   []string
+	records map[string]CommandRecord
+}
+
+// Save implements CommandHistory.Save
+func (h *InMemoryCommandHistory) Save(record CommandRecord) error {
+	h.Lock()
+	defer h.Unlock()
+
+	if _, ok := h.records[record.ID]; !ok {
+		h.order = append(h.order, record.ID)
+	}
+
+	h.records[record.ID] = record
+
+	// drop oldest records
+	for len(h.order) > h.size {
+		delete(h.records, h.order[0])
+		h.order = h.order[1:]
+	}
+
+	return nil
+}
+
+// Get implements CommandHistory.Get
+func (h *InMemoryCommandHistory) Get(id string) (CommandRecord, bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	record, ok := h.records[id]
+
+	return record, ok
+}
+
+// List implements CommandHistory.List
+func (h *InMemoryCommandHistory) List(sn string, limit int) []CommandRecord {
+	h.RLock()
+	defer h.RUnlock()
+
+	records := make([]CommandRecord, 0)
+	for i := len(h.order) - 1; i >= 0; i-- {
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+
+		record := h.records[h.order[i]]
+		if sn == "" || record.SN == sn {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// NewCommandHistory create in memory history which keep
+// up to given number of records
+func NewCommandHistory(size int) *InMemoryCommandHistory {
+	if size < 1 {
+		size = defaultHistorySize
+	}
+
+	return &InMemoryCommandHistory{
+		size:    size,
+		records: make(map[string]CommandRecord),
+	}
+}
+
+// saveCommandRecord persist command record, error only logged
+// as history must not interrupt command delivery
+func (s *Server) saveCommandRecord(record CommandRecord) {
+	if err := s.history.Save(record); err != nil {
+		log.Printf("failed to record command %s: %v\n", record.ID, err)
+	}
+}
+
+// recordCommand update history record of command. command unknown
+// to history is not saved, returned record only carry the update
+func (s *Server) recordCommand(id string, update func(*CommandRecord)) CommandRecord {
+	record, ok := s.history.Get(id)
+	if !ok {
+		record = CommandRecord{ID: id}
+	}
+
+	update(&record)
+
+	if ok {
+		s.saveCommandRecord(record)
+	}
+
+	return record
+}
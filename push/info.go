@@ -0,0 +1,99 @@
+package push
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// position of supported function flag on INFO
+const (
+	FeatureFingerPrint = iota
+	FeatureFace
+	FeatureUserPhoto
+)
+
+// InfoHook define callback upon device reporting its information
+type InfoHook interface {
+	OnDeviceInfo(sn string, info DeviceInfo)
+}
+
+// DeviceInfo represent device information which periodically
+// reported on INFO request
+type DeviceInfo struct {
+	FirmwareVersion string `json:"firmware_version"`
+
+	// enrolled data count
+	UserCount        int `json:"user_count"`
+	FingerPrintCount int `json:"fingerprint_count"`
+	AttLogCount      int `json:"attlog_count"`
+
+	// device ip address
+	IP string `json:"ip"`
+
+	// fingerprint and face algorithm version
+	FingerPrintVersion string `json:"fingerprint_version"`
+	FaceVersion        string `json:"face_version"`
+
+	// number of face templates required per user
+	// and enrolled face count
+	RequiredFaceCount int `json:"required_face_count"`
+	FaceCount         int `json:"face_count"`
+
+	// supported function flags, each digit represent
+	// single function (see const feature)
+	Features string `json:"features"`
+}
+
+// Supports check whether function flag on given position is set
+func (i DeviceInfo) Supports(feature int) bool {
+	return feature >= 0 && feature < len(i.Features) && i.Features[feature] == '1'
+}
+
+// Marshall implement payload.Marshall interface
+func (i DeviceInfo) Marshall() ([]byte, error) {
+	fields := []string{
+		i.FirmwareVersion,
+		strconv.Itoa(i.UserCount),
+		strconv.Itoa(i.FingerPrintCount),
+		strconv.Itoa(i.AttLogCount),
+		i.IP,
+		i.FingerPrintVersion,
+		i.FaceVersion,
+		strconv.Itoa(i.RequiredFaceCount),
+		strconv.Itoa(i.FaceCount),
+		i.Features,
+	}
+
+	return []byte(strings.Join(fields, ",")), nil
+}
+
+// Unmarshall implement payload.Unmarshall interface.
+// missing trailing fields, sent by older firmware, left empty
+func (i *DeviceInfo) Unmarshall(b []byte) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := bytes.Split(b, []byte(","))
+	field := func(n int) value {
+		if n >= len(fields) {
+			return nil
+		}
+
+		return value(bytes.TrimSpace(fields[n]))
+	}
+
+	i.FirmwareVersion = field(0).ToString()
+	i.UserCount = field(1).ToInt()
+	i.FingerPrintCount = field(2).ToInt()
+	i.AttLogCount = field(3).ToInt()
+	i.IP = field(4).ToString()
+	i.FingerPrintVersion = field(5).ToString()
+	i.FaceVersion = field(6).ToString()
+	i.RequiredFaceCount = field(7).ToInt()
+	i.FaceCount = field(8).ToInt()
+	i.Features = field(9).ToString()
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package push
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fixed offset location name, e.g. UTC+7 or UTC-03:30
+var fixedZonePattern = regexp.MustCompile(`^UTC([+-])(\d{1,2})(?::(\d{2}))?$`)
+
+// loaded locations by name
+var locations sync.Map
+
+// LoadDeviceLocation return location of device clock by name,
+// either IANA time zone, e.g. Asia/Jakarta, or fixed
+// offset from UTC, e.g. UTC+7
+func LoadDeviceLocation(name string) (*time.Location, error) {
+	if v, ok := locations.Load(name); ok {
+		return v.(*time.Location), nil
+	}
+
+	var (
+		loc *time.Location
+		err error
+	)
+
+	if m := fixedZonePattern.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+
+		loc = time.FixedZone(name, offset)
+	} else if loc, err = time.LoadLocation(name); err != nil {
+		return nil, err
+	}
+
+	locations.Store(name, loc)
+
+	return loc, nil
+}
+
+// maxTimeZoneHours is largest offset in hours, TimeZone of
+// larger magnitude is offset in minutes
+const maxTimeZoneHours = 14
+
+// timeZoneSeconds return offset in seconds of TimeZone sent to
+// device, which is in hours or minutes when not whole hour
+func timeZoneSeconds(tz int) int {
+	if tz >= -maxTimeZoneHours && tz <= maxTimeZoneHours {
+		return tz * 3600
+	}
+
+	return tz * 60
+}
+
+// fixedZoneName return location name of TimeZone sent to device
+func fixedZoneName(tz int) string {
+	offset := timeZoneSeconds(tz)
+	if offset%3600 == 0 {
+		return fmt.Sprintf("UTC%+d", offset/3600)
+	}
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+// timeZoneOffset return TimeZone sent to device of location at given
+// time, in hours or minutes when not whole hour, e.g. 330 of +05:30
+func timeZoneOffset(loc *time.Location, now time.Time) int {
+	_, offset := now.In(loc).Zone()
+	if offset%3600 == 0 {
+		return offset / 3600
+	}
+
+	return offset / 60
+}
+
+// location return location of device clock, taken from exchange,
+// then DeviceLocation option, otherwise server local time
+func (s *Server) location(sn string) *time.Location {
+	if status, ok := s.registry.Get(sn); ok && status.Location != "" {
+		loc, err := LoadDeviceLocation(status.Location)
+		if err == nil {
+			return loc
+		}
+
+		log.Printf("invalid location %s of %s: %v\n", status.Location, sn, err)
+	}
+
+	if s.option.DeviceLocation != nil {
+		return s.option.DeviceLocation
+	}
+
+	return time.Local
+}
+
+// parseDeviceTime parse timestamp written by device clock
+func parseDeviceTime(layout string, value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// applyLocation record location of device clock defined on exchange,
+// and derive time zone sent to device when not defined
+func (s *Server) applyLocation(sn string, cmd *ExchangeCommand) {
+	var loc *time.Location
+	if cmd.Location != "" {
+		var err error
+		if loc, err = LoadDeviceLocation(cmd.Location); err != nil {
+			log.Printf("invalid location %s of %s: %v\n", cmd.Location, sn, err)
+			cmd.Location = ""
+		}
+	}
+
+	// time zone without location, logs written on fixed offset
+	if cmd.Location == "" && cmd.TimeZone != 0 {
+		cmd.Location = fixedZoneName(cmd.TimeZone)
+	}
+
+	s.registry.SetLocation(sn, cmd.Location)
+
+	if loc != nil && cmd.TimeZone == 0 {
+		cmd.TimeZone = timeZoneOffset(loc, time.Now())
+	}
+}
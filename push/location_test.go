@@ -0,0 +1,185 @@
+package push
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDeviceLocation(t *testing.T) {
+	cases := []struct {
+		name   string
+		offset int
+	}{
+		{"UTC+7", 7 * 3600},
+		{"UTC-03:30", -(3*3600 + 30*60)},
+		{"UTC+0", 0},
+	}
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+	for _, c := range cases {
+		loc, err := LoadDeviceLocation(c.name)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if _, offset := now.In(loc).Zone(); offset != c.offset {
+			t.Errorf("expected offset %d of %s but returned %d", c.offset, c.name, offset)
+		}
+	}
+
+	if _, err := LoadDeviceLocation("Asia/Jakarta"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := LoadDeviceLocation("UTC+7:5"); err == nil {
+		t.Error("expected invalid location error")
+	}
+}
+
+func TestTimeZoneOffset(t *testing.T) {
+	cases := []struct {
+		name string
+		tz   int
+	}{
+		{"UTC+7", 7},
+		{"UTC-03:30", -210},
+		{"Asia/Kolkata", 330},
+		{"Asia/Kathmandu", 345},
+	}
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+	for _, c := range cases {
+		loc, err := LoadDeviceLocation(c.name)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		tz := timeZoneOffset(loc, now)
+		if tz != c.tz {
+			t.Errorf("expected time zone %d of %s but returned %d", c.tz, c.name, tz)
+			t.FailNow()
+		}
+
+		// offset kept on location named after time zone
+		fixed, err := LoadDeviceLocation(fixedZoneName(tz))
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if _, want := now.In(loc).Zone(); timeZoneSeconds(tz) != want {
+			t.Errorf("expected offset %d of %s but returned %d", want, c.name, timeZoneSeconds(tz))
+		}
+
+		if _, offset := now.In(fixed).Zone(); offset != timeZoneSeconds(tz) {
+			t.Errorf("expected offset %d of %s but returned %d", timeZoneSeconds(tz), fixedZoneName(tz), offset)
+		}
+	}
+}
+
+func TestRealtimeTimeSync(t *testing.T) {
+	hook := &locationHook{location: "UTC-03:30"}
+	s := NewServer(&ServerOption{}, hook)
+	s.exchange(Device{SN: "123456789"})
+
+	w := httptest.NewRecorder()
+	s.handleRealtimeData(w, httptest.NewRequest("GET", "/iclock/rtdata?SN=123456789&type=time", nil))
+
+	if !strings.HasSuffix(w.Body.String(), ",ServerTZ=-0330") {
+		t.Errorf("expected time zone of device location but returned %s", w.Body.String())
+	}
+}
+
+type locationHook struct {
+	location string
+	timeZone int
+	logs     []AttendanceLog
+}
+
+func (h *locationHook) OnInitialExchange(d Device) *ExchangeCommand {
+	return &ExchangeCommand{SN: d.SN, Location: h.location, TimeZone: h.timeZone}
+}
+
+func (h *locationHook) OnAttendanceLog(sn string, l AttendanceLog) {
+	h.logs = append(h.logs, l)
+}
+
+func (h *locationHook) OnOperationLog(sn string, l OperationLog) {}
+
+func TestUploadInDeviceLocation(t *testing.T) {
+	hook := &locationHook{location: "UTC+7"}
+	s := NewServer(&ServerOption{DeviceLocation: time.UTC}, hook)
+
+	cmd := s.exchange(Device{SN: "123456789"})
+	if cmd == nil || cmd.TimeZone != 7 {
+		t.Errorf("expected time zone derived from location but returned %+v", cmd)
+		t.FailNow()
+	}
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader("1\t2019-05-20 08:00:00\t0\t1\n")))
+
+	expected := time.Date(2019, 5, 20, 1, 0, 0, 0, time.UTC)
+	if len(hook.logs) != 1 || !hook.logs[0].Time.Equal(expected) {
+		t.Errorf("expected %v but returned %+v", expected, hook.logs)
+		t.FailNow()
+	}
+
+	// device without location fall back to option
+	hook.location = ""
+	s.exchange(Device{SN: "123456789"})
+
+	w = httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader("1\t2019-05-20 08:00:00\t0\t1\n")))
+
+	expected = time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+	if len(hook.logs) != 2 || !hook.logs[1].Time.Equal(expected) {
+		t.Errorf("expected %v but returned %+v", expected, hook.logs)
+	}
+}
+
+func TestUploadInDeviceTimeZone(t *testing.T) {
+	hook := &locationHook{timeZone: 330}
+	s := NewServer(&ServerOption{DeviceLocation: time.UTC}, hook)
+
+	cmd := s.exchange(Device{SN: "123456789"})
+	if cmd == nil || cmd.Location != "UTC+05:30" || cmd.TimeZone != 330 {
+		t.Errorf("expected location derived from time zone but returned %+v", cmd)
+		t.FailNow()
+	}
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader("1\t2019-05-20 08:00:00\t0\t1\n")))
+
+	expected := time.Date(2019, 5, 20, 2, 30, 0, 0, time.UTC)
+	if len(hook.logs) != 1 || !hook.logs[0].Time.Equal(expected) {
+		t.Errorf("expected %v but returned %+v", expected, hook.logs)
+	}
+}
+
+func TestUploadSkipInvalidRecord(t *testing.T) {
+	hook := &locationHook{}
+	s := NewServer(&ServerOption{DeviceLocation: time.UTC}, hook)
+	s.RegisterDevice("123456789")
+
+	body := "1\t2019-05-20 08:00:00\t0\t1\n" +
+		"2\tnot a time\t0\t1\n" +
+		"3\t2019-05-20 08:10:00\t0\t1\n"
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader(body)))
+
+	// batch acknowledged, so valid records not delivered twice
+	if w.Code != 200 || w.Body.String() != "OK" {
+		t.Errorf("expected batch acknowledged but returned %d %s", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	if len(hook.logs) != 2 || hook.logs[0].PIN != "1" || hook.logs[1].PIN != "3" {
+		t.Errorf("expected valid records delivered but returned %+v", hook.logs)
+	}
+}
@@ -0,0 +1,123 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidLogRecord returned when uploaded log record
+// doesn't have required fields
+var ErrInvalidLogRecord = errors.New("Invalid log record")
+
+// time layout of uploaded log records
+const logTimeLayout = "2006-01-02 15:04:05"
+
+// record prefix of operation log
+var operationLogPrefix = []byte("OPLOG ")
+
+// AttendanceHook define callback upon receiving
+// attendance and operation logs uploaded by device
+type AttendanceHook interface {
+	OnAttendanceLog(sn string, log AttendanceLog)
+	OnOperationLog(sn string, log OperationLog)
+}
+
+// AttendanceLog represent single attendance record of ATTLOG table
+type AttendanceLog struct {
+	PIN  string    `json:"pin"`
+	Time time.Time `json:"time"`
+
+	// check in / out status
+	Status int `json:"status"`
+
+	// verification mode, e.g. finger print, card
+	Verify int `json:"verify"`
+
+	WorkCode string `json:"work_code,omitempty"`
+}
+
+// Unmarshall implement payload.Unmarshall interface,
+// time parsed on server local time
+func (l *AttendanceLog) Unmarshall(b []byte) error {
+	return l.unmarshallIn(b, time.Local)
+}
+
+// unmarshallIn decode record which time written on given location
+func (l *AttendanceLog) unmarshallIn(b []byte, loc *time.Location) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := strings.Split(string(b), "\t")
+	if len(fields) < 2 {
+		return ErrInvalidLogRecord
+	}
+
+	t, err := parseDeviceTime(logTimeLayout, fields[1], loc)
+	if err != nil {
+		return err
+	}
+
+	l.PIN = fields[0]
+	l.Time = t
+	l.Status = logField(fields, 2).ToInt(0)
+	l.Verify = logField(fields, 3).ToInt(0)
+	l.WorkCode = logField(fields, 4).ToString()
+
+	return nil
+}
+
+// OperationLog represent operation record (OPLOG)
+// of OPERLOG table, e.g. enroll user or clear data
+type OperationLog struct {
+	// operation code
+	Type int `json:"type"`
+
+	// administrator who perform operation
+	Operator string    `json:"operator"`
+	Time     time.Time `json:"time"`
+
+	// operation objects, depend on operation type
+	Values []string `json:"values"`
+}
+
+// Unmarshall implement payload.Unmarshall interface,
+// time parsed on server local time
+func (l *OperationLog) Unmarshall(b []byte) error {
+	return l.unmarshallIn(b, time.Local)
+}
+
+// unmarshallIn decode record which time written on given location
+func (l *OperationLog) unmarshallIn(b []byte, loc *time.Location) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := strings.Split(string(bytes.TrimPrefix(b, operationLogPrefix)), "\t")
+	if len(fields) < 3 {
+		return ErrInvalidLogRecord
+	}
+
+	t, err := parseDeviceTime(logTimeLayout, fields[2], loc)
+	if err != nil {
+		return err
+	}
+
+	l.Type = value(fields[0]).ToInt(0)
+	l.Operator = fields[1]
+	l.Time = t
+	l.Values = fields[3:]
+
+	return nil
+}
+
+// logField return field at given index, nil if not exists
+func logField(fields []string, i int) value {
+	if i >= len(fields) {
+		return nil
+	}
+
+	return value(strings.TrimSpace(fields[i]))
+}
@@ -0,0 +1,182 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// option command related error
+var (
+	ErrInvalidOptionKey   = errors.New("Option key must not be empty or contain '=' or whitespace")
+	ErrInvalidOptionValue = errors.New("Option value must not contain tab or line break")
+)
+
+// OptionChange represent device parameter changed by SetOptions
+type OptionChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// validOptionKey check whether key can be sent on option command
+func validOptionKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "= \t\r\n")
+}
+
+// validOptionValue check whether value can be sent on option
+// command, separator would inject another command or field
+func validOptionValue(value string) bool {
+	return !strings.ContainsAny(value, "\t\r\n")
+}
+
+// InfoCommand create command which request device information
+// and parameters, reported as key value lines on result payload
+func InfoCommand() Command {
+	return Command{CMD: "INFO"}
+}
+
+// GetOptionCommand create command which request value of parameter
+func GetOptionCommand(key string) (Command, error) {
+	if !validOptionKey(key) {
+		return Command{}, ErrInvalidOptionKey
+	}
+
+	return Command{CMD: "GET OPTION FROM", Payload: []byte(key)}, nil
+}
+
+// SetOptionCommand create command which change value of parameter,
+// device apply changed parameters upon ReloadOptionsCommand
+func SetOptionCommand(key string, value string) (Command, error) {
+	if !validOptionKey(key) {
+		return Command{}, ErrInvalidOptionKey
+	}
+
+	if !validOptionValue(value) {
+		return Command{}, ErrInvalidOptionValue
+	}
+
+	return Command{CMD: "SET OPTION", Payload: []byte(key + "=" + value)}, nil
+}
+
+// ReloadOptionsCommand create command which apply changed parameters
+func ReloadOptionsCommand() Command {
+	return Command{CMD: "RELOAD OPTIONS"}
+}
+
+// doOptions send option commands on single batch and return result
+// payload of each succeed command, stop on first failed command
+func (s *Server) doOptions(ctx context.Context, sn string, cmds ...Command) ([]map[string]string, error) {
+	resps, err := s.doAll(ctx, sn, cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]map[string]string, 0, len(resps))
+	for _, resp := range resps {
+		if err := resp.Err(); err != nil {
+			return values, err
+		}
+
+		values = append(values, parseOptions(resp.Payload, "\n"))
+	}
+
+	return values, nil
+}
+
+// GetOptions read device parameters. given keys read through GET
+// OPTION queued at once, otherwise all parameters reported by INFO
+func (s *Server) GetOptions(ctx context.Context, sn string, keys ...string) (map[string]string, error) {
+	if len(keys) == 0 {
+		values, err := s.doOptions(ctx, sn, InfoCommand())
+		if err != nil {
+			return nil, err
+		}
+
+		return values[0], nil
+	}
+
+	cmds := make([]Command, 0, len(keys))
+	for _, key := range keys {
+		cmd, err := GetOptionCommand(key)
+		if err != nil {
+			return nil, err
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	values, err := s.doOptions(ctx, sn, cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string)
+	for i, key := range keys {
+		if v, ok := values[i][key]; ok {
+			options[key] = v
+		}
+	}
+
+	return options, nil
+}
+
+// SetOptions write device parameters which differ from current
+// values, then reload options so device apply them. changed
+// parameters returned ordered by key, nothing sent when all
+// parameters already have given values. commands of each step
+// queued at once, so device handle them on single poll
+func (s *Server) SetOptions(ctx context.Context, sn string, options map[string]string) ([]OptionChange, error) {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		if !validOptionKey(key) {
+			return nil, ErrInvalidOptionKey
+		}
+
+		if !validOptionValue(options[key]) {
+			return nil, ErrInvalidOptionValue
+		}
+
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]OptionChange, 0)
+	if len(keys) == 0 {
+		return changes, nil
+	}
+
+	current, err := s.GetOptions(ctx, sn, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]Command, 0, len(keys))
+	for _, key := range keys {
+		old, ok := current[key]
+		if ok && old == options[key] {
+			continue
+		}
+
+		cmd, _ := SetOptionCommand(key, options[key])
+		cmds = append(cmds, cmd)
+		changes = append(changes, OptionChange{Key: key, Old: old, New: options[key]})
+	}
+
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	// changes reported up to failed command
+	values, err := s.doOptions(ctx, sn, cmds...)
+	if err != nil {
+		return changes[:len(values)], err
+	}
+
+	if _, err := s.doOptions(ctx, sn, ReloadOptionsCommand()); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
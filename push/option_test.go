@@ -0,0 +1,173 @@
+package push
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandResponsePayload(t *testing.T) {
+	var resp CommandResponse
+	if err := Unmarshall([]byte("ID=1&Return=0&CMD=INFO\n~DeviceName=F22\nMAC=00:17:61\n"), &resp); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if resp.ID != "1" || resp.CMD != "INFO" || string(resp.Payload) != "~DeviceName=F22\nMAC=00:17:61" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	if err := Unmarshall([]byte("ID=2&Return=0&CMD=REBOOT\n"), &resp); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if resp.CMD != "REBOOT" || resp.Payload != nil {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestSplitCommandResponses(t *testing.T) {
+	body := "ID=1&Return=0&CMD=DATA\nID=2&Return=0&CMD=INFO\n~DeviceName=F22\nMAC=00:17:61\n" +
+		"ID=3&Return=-1002&CMD=DATA\r\nID=4&Return=0&CMD=GET OPTION\nLockOn=5\n"
+
+	responses, err := splitCommandResponses([]byte(body))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(responses) != 4 {
+		t.Errorf("expected 4 results but returned %+v", responses)
+		t.FailNow()
+	}
+
+	if responses[0].ID != "1" || responses[0].Payload != nil {
+		t.Errorf("expected result without payload but returned %+v", responses[0])
+	}
+
+	if responses[1].ID != "2" || string(responses[1].Payload) != "~DeviceName=F22\nMAC=00:17:61" {
+		t.Errorf("expected info payload but returned %+v", responses[1])
+	}
+
+	if responses[2].ID != "3" || responses[2].CMD != "DATA" || responses[2].Return != -1002 {
+		t.Errorf("expected failed result but returned %+v", responses[2])
+	}
+
+	if responses[3].ID != "4" || string(responses[3].Payload) != "LockOn=5" {
+		t.Errorf("expected option payload but returned %+v", responses[3])
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	type result struct {
+		options map[string]string
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		options, err := s.GetOptions(context.Background(), "123456789")
+		done <- result{options, err}
+	}()
+
+	polled := pollCommand(s, "123456789")
+	if !strings.HasSuffix(polled, ":INFO\n") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	respondCommand(s, "123456789", polled, "0", "~DeviceName=F22", "UserCount=12", "IPAddress=192.168.1.201")
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if r.options["~DeviceName"] != "F22" || r.options["UserCount"] != "12" || r.options["IPAddress"] != "192.168.1.201" {
+		t.Errorf("unexpected options %v", r.options)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	type result struct {
+		changes []OptionChange
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		changes, err := s.SetOptions(ctx, "123456789", map[string]string{"LockOn": "5", "VOLUME": "60"})
+		done <- result{changes, err}
+	}()
+
+	// both values requested on single poll, only volume differ
+	polled := pollCommand(s, "123456789")
+	lines := strings.Split(strings.TrimSpace(polled), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], ":GET OPTION FROM LockOn") || !strings.HasSuffix(lines[1], ":GET OPTION FROM VOLUME") {
+		t.Errorf("unexpected commands %s", polled)
+		t.FailNow()
+	}
+
+	body := "ID=" + strings.SplitN(lines[0], ":", 3)[1] + "&Return=0&CMD=GET OPTION\nLockOn=5\n" +
+		"ID=" + strings.SplitN(lines[1], ":", 3)[1] + "&Return=0&CMD=GET OPTION\nVOLUME=80\n"
+
+	w := httptest.NewRecorder()
+	s.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN=123456789", strings.NewReader(body)))
+
+	polled = pollCommand(s, "123456789")
+	if !strings.HasSuffix(polled, ":SET OPTION VOLUME=60\n") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+	respondCommand(s, "123456789", polled, "0")
+
+	polled = pollCommand(s, "123456789")
+	if !strings.HasSuffix(polled, ":RELOAD OPTIONS\n") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+	respondCommand(s, "123456789", polled, "0")
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if len(r.changes) != 1 || r.changes[0] != (OptionChange{Key: "VOLUME", Old: "80", New: "60"}) {
+		t.Errorf("unexpected changes %+v", r.changes)
+	}
+}
+
+func TestSetOptionsInvalidKey(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	if _, err := s.SetOptions(context.Background(), "123456789", map[string]string{"Lock On": "5"}); err != ErrInvalidOptionKey {
+		t.Errorf("expected invalid option key but returned %v", err)
+		t.FailNow()
+	}
+
+	// value inject another command
+	if _, err := s.SetOptions(context.Background(), "123456789", map[string]string{"LockOn": "5\nC:1:CLEAR DATA"}); err != ErrInvalidOptionValue {
+		t.Errorf("expected invalid option value but returned %v", err)
+		t.FailNow()
+	}
+
+	if _, err := SetOptionCommand("LockOn", "5\t1"); err != ErrInvalidOptionValue {
+		t.Errorf("expected invalid option value but returned %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// photo related error
+var (
+	ErrInvalidPhotoHeader = errors.New("Invalid photo header")
+	ErrPhotoSizeMismatch  = errors.New("Photo data doesn't match the given size")
+	ErrPhotoSNMismatch    = errors.New("Photo header SN doesn't match the uploading device")
+	ErrInvalidPhotoPath   = errors.New("Photo SN or file name is not valid path element")
+)
+
+// layout of timestamp on photo file name
+const photoTimeLayout = "20060102150405"
+
+// PhotoHook define callback upon receiving attendance photo
+type PhotoHook interface {
+	OnAttendancePhoto(photo AttendancePhoto)
+}
+
+// PhotoStore persist attendance photos
+type PhotoStore interface {
+	Save(photo AttendancePhoto) error
+}
+
+// AttendancePhoto represent photo captured by device
+// upon verification
+type AttendancePhoto struct {
+	// device serial no
+	SN string `json:"sn"`
+
+	// user pin, empty when verification failed
+	PIN string `json:"pin"`
+
+	// capture time
+	Time time.Time `json:"time"`
+
+	// original file name, e.g. 20190520083010-1.jpg
+	FileName string `json:"file_name"`
+
+	// jpeg image
+	Image []byte `json:"image"`
+}
+
+// Unmarshall implement payload.Unmarshall interface. payload consist
+// of header lines followed by null byte and jpeg image. SN, when
+// already set, must match the header. capture time parsed on
+// server local time
+func (p *AttendancePhoto) Unmarshall(b []byte) error {
+	return p.unmarshallIn(b, time.Local)
+}
+
+// unmarshallIn decode photo which capture time written on given location
+func (p *AttendancePhoto) unmarshallIn(b []byte, loc *time.Location) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	idx := bytes.IndexByte(b, 0x00)
+	if idx == -1 {
+		return ErrInvalidPhotoHeader
+	}
+
+	header, image := b[:idx], b[idx+1:]
+
+	p.FileName = extractValue(header, "PIN", lf).ToString()
+	if p.FileName == "" {
+		return ErrInvalidPhotoHeader
+	}
+
+	// device which upload photo is already known,
+	// header may only repeat it
+	if sn := extractValue(header, "SN", lf).ToString(); sn != "" {
+		if p.SN != "" && sn != p.SN {
+			return ErrPhotoSNMismatch
+		}
+
+		p.SN = sn
+	}
+
+	if size := extractValue(header, "size", lf).ToInt(-1); size != -1 && size != len(image) {
+		return ErrPhotoSizeMismatch
+	}
+
+	// file name consist of capture time and optional user pin
+	name := strings.TrimSuffix(p.FileName, filepath.Ext(p.FileName))
+	parts := strings.SplitN(name, "-", 2)
+
+	t, err := parseDeviceTime(photoTimeLayout, parts[0], loc)
+	if err != nil {
+		return ErrInvalidPhotoHeader
+	}
+
+	p.Time = t
+	if len(parts) == 2 {
+		p.PIN = parts[1]
+	}
+
+	p.Image = image
+
+	return nil
+}
+
+// FileSystemPhotoStore implement PhotoStore using file system.
+// photo saved as <root>/<SN>/<yyyy-mm-dd>/<file name>
+type FileSystemPhotoStore struct {
+	Root string
+}
+
+// Save implements PhotoStore.Save
+func (s *FileSystemPhotoStore) Save(photo AttendancePhoto) error {
+	if !pathElement(photo.SN) || !pathElement(photo.FileName) {
+		return ErrInvalidPhotoPath
+	}
+
+	dir := filepath.Join(s.Root, photo.SN, photo.Time.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, photo.FileName), photo.Image, 0644)
+}
+
+// pathElement check whether name is single path element
+// which stay within its parent directory
+func pathElement(name string) bool {
+	switch name {
+	case "", ".", "..":
+		return false
+	}
+
+	return !strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// NewFileSystemPhotoStore create photo store under given directory
+func NewFileSystemPhotoStore(root string) *FileSystemPhotoStore {
+	return &FileSystemPhotoStore{Root: root}
+}
@@ -0,0 +1,164 @@
+package push
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAttendancePhotoUnmarshall(t *testing.T) {
+	image := []byte{0xff, 0xd8, 0x00, 0xff, 0xd9}
+	payload := append([]byte("PIN=20190520083010-12.jpg\nSN=123456789\nsize=5\nCMD=uploadphoto\x00"), image...)
+
+	var photo AttendancePhoto
+	if err := Unmarshall(payload, &photo); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if photo.PIN != "12" || photo.SN != "123456789" {
+		t.Errorf("unexpected photo %s %s", photo.PIN, photo.SN)
+		t.FailNow()
+	}
+
+	if !photo.Time.Equal(time.Date(2019, 5, 20, 8, 30, 10, 0, time.Local)) {
+		t.Errorf("unexpected capture time %v", photo.Time)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(photo.Image, image) {
+		t.Errorf("unexpected image %x", photo.Image)
+		t.FailNow()
+	}
+
+	// size mismatch
+	payload = append([]byte("PIN=20190520083010-12.jpg\nSN=123456789\nsize=10\nCMD=uploadphoto\x00"), image...)
+	if err := Unmarshall(payload, &photo); err != ErrPhotoSizeMismatch {
+		t.Errorf("expected size mismatch but returned %v", err)
+	}
+
+	// header can't file photo under other device
+	photo = AttendancePhoto{SN: "987654321"}
+	payload = append([]byte("PIN=20190520083010-12.jpg\nSN=123456789\nsize=5\x00"), image...)
+	if err := Unmarshall(payload, &photo); err != ErrPhotoSNMismatch {
+		t.Errorf("expected sn mismatch but returned %v", err)
+	}
+}
+
+func TestFileSystemPhotoStorePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photo")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSystemPhotoStore(filepath.Join(dir, "photos"))
+	at := time.Date(2019, 5, 20, 8, 30, 10, 0, time.UTC)
+
+	if err := store.Save(AttendancePhoto{SN: "123456789", Time: at, FileName: "20190520083010-12.jpg"}); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "photos", "123456789", "2019-05-20", "20190520083010-12.jpg")); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	for _, sn := range []string{"", ".", "..", "../other"} {
+		if err := store.Save(AttendancePhoto{SN: sn, Time: at, FileName: "20190520083010-12.jpg"}); err != ErrInvalidPhotoPath {
+			t.Errorf("expected invalid path of sn %q but returned %v", sn, err)
+		}
+	}
+}
+
+func TestUserPhotoUnmarshall(t *testing.T) {
+	image := []byte{0xff, 0xd8, 0xff, 0xd9}
+
+	cmd, err := UpdateUserPhotoCommand(UserPhoto{PIN: "1", Image: image})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	var photo UserPhoto
+	if err := Unmarshall(append([]byte("USERPIC "), cmd.Payload...), &photo); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if photo.PIN != "1" || !bytes.Equal(photo.Image, image) {
+		t.Errorf("unexpected photo %s %x", photo.PIN, photo.Image)
+		t.FailNow()
+	}
+
+	// size mismatch
+	if err := Unmarshall([]byte("USERPIC PIN=1\tSize=100\tContent=/9j/2Q=="), &photo); err != ErrUserPhotoSizeMismatch {
+		t.Errorf("expected size mismatch but returned %v", err)
+	}
+}
+
+func TestBioPhotoRoundTrip(t *testing.T) {
+	image := []byte{0xff, 0xd8, 0xff, 0xd9}
+
+	cmd, err := UpdateBioPhotoCommand(BioPhoto{PIN: "12", Type: BioPhotoFace, Image: image})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	var photo BioPhoto
+	if err := Unmarshall(append([]byte("BIOPHOTO "), cmd.Payload...), &photo); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if photo.PIN != "12" || photo.Type != BioPhotoFace || !bytes.Equal(photo.Image, image) {
+		t.Errorf("unexpected photo %s %d %x", photo.PIN, photo.Type, photo.Image)
+		t.FailNow()
+	}
+
+	if _, err := UpdateBioPhotoCommand(BioPhoto{PIN: "12", Type: BioPhotoFace}); err != ErrEmptyPayload {
+		t.Errorf("expected empty payload but returned %v", err)
+	}
+}
+
+func TestDeletePhotoCommand(t *testing.T) {
+	cmd, err := DeleteUserPhotoCommand("12")
+	if err != nil || cmd.CMD != "DATA DELETE USERPIC" || string(cmd.Payload) != "PIN=12" {
+		t.Errorf("unexpected command %s %s %v", cmd.CMD, cmd.Payload, err)
+		t.FailNow()
+	}
+
+	cmd, err = DeleteBioPhotoCommand("12", BioPhotoFace)
+	if err != nil || cmd.CMD != "DATA DELETE BIOPHOTO" || string(cmd.Payload) != "PIN=12\tType=9" {
+		t.Errorf("unexpected command %s %s %v", cmd.CMD, cmd.Payload, err)
+	}
+}
+
+func TestPhotoCommandInvalidPIN(t *testing.T) {
+	image := []byte{0xff, 0xd8, 0xff, 0xd9}
+
+	// pin inject another field or command
+	for _, pin := range []string{"", "12\tType=1", "12\nC:1:CLEAR DATA", "12\r"} {
+		if _, err := UpdateUserPhotoCommand(UserPhoto{PIN: pin, Image: image}); err != ErrInvalidPIN {
+			t.Errorf("expected invalid pin of user photo %q but returned %v", pin, err)
+		}
+
+		if _, err := UpdateBioPhotoCommand(BioPhoto{PIN: pin, Type: BioPhotoFace, Image: image}); err != ErrInvalidPIN {
+			t.Errorf("expected invalid pin of bio photo %q but returned %v", pin, err)
+		}
+
+		if _, err := DeleteUserPhotoCommand(pin); err != ErrInvalidPIN {
+			t.Errorf("expected invalid pin of user photo deletion %q but returned %v", pin, err)
+		}
+
+		if _, err := DeleteBioPhotoCommand(pin, BioPhotoFace); err != ErrInvalidPIN {
+			t.Errorf("expected invalid pin of bio photo deletion %q but returned %v", pin, err)
+		}
+	}
+}
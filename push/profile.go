@@ -0,0 +1,350 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrProfileWithoutName returned when profile doesn't have name
+var ErrProfileWithoutName = errors.New("Profile name is empty")
+
+// specificity of profile criteria, more
+// specific profile override less specific
+const (
+	matchDefault = iota
+	matchModel
+	matchGroup
+	matchPattern
+	matchSN
+)
+
+// Profile define transmission settings of devices. devices matched
+// by all given criteria, and settings of more specific profile, i.e.
+// sn, then pattern, group and model, override less specific ones.
+// unset settings inherit from less specific profile
+type Profile struct {
+	Name string `json:"name"`
+
+	// serial numbers of devices
+	SN []string `json:"sn,omitempty"`
+
+	// serial number glob pattern, e.g. BRM9*
+	Pattern string `json:"pattern,omitempty"`
+
+	// group assigned by operator
+	Group string `json:"group,omitempty"`
+
+	// device name reported on registry, e.g. F22
+	Model string `json:"model,omitempty"`
+
+	ErrorDelay    *int    `json:"error_delay,omitempty"`
+	Delay         *int    `json:"delay,omitempty"`
+	TransTimes    *string `json:"trans_times,omitempty"`
+	TransInterval *int    `json:"trans_interval,omitempty"`
+	TransFlag     *string `json:"trans_flag,omitempty"`
+	Realtime      *int    `json:"realtime,omitempty"`
+	Encrypt       *int    `json:"encrypt,omitempty"`
+	ServerVer     *string `json:"server_ver,omitempty"`
+
+	// time zone offset in hours, or minutes when not
+	// whole hour, e.g. 330 of +05:30
+	TimeZone *int `json:"time_zone,omitempty"`
+
+	// time zone location, e.g. Asia/Jakarta. offset sent to
+	// device computed on exchange, so daylight saving followed.
+	// ignored when time zone offset defined. both also define
+	// location of device timestamps
+	Location string `json:"location,omitempty"`
+}
+
+// specificity return most specific criteria of profile
+func (p Profile) specificity() int {
+	switch {
+	case len(p.SN) > 0:
+		return matchSN
+	case p.Pattern != "":
+		return matchPattern
+	case p.Group != "":
+		return matchGroup
+	case p.Model != "":
+		return matchModel
+	}
+
+	return matchDefault
+}
+
+// match check whether device matched by all criteria of profile
+func (p Profile) match(d Device, groups []string) bool {
+	if len(p.SN) > 0 && !containsString(p.SN, d.SN) {
+		return false
+	}
+
+	if p.Pattern != "" {
+		if ok, _ := path.Match(p.Pattern, d.SN); !ok {
+			return false
+		}
+	}
+
+	if p.Group != "" && !containsString(groups, p.Group) {
+		return false
+	}
+
+	if p.Model != "" && p.Model != deviceModel(d) {
+		return false
+	}
+
+	return true
+}
+
+// apply override exchange command by settings of profile
+func (p Profile) apply(cmd *ExchangeCommand, now time.Time) {
+	if p.ErrorDelay != nil {
+		cmd.ErrorDelay = *p.ErrorDelay
+	}
+
+	if p.Delay != nil {
+		cmd.Delay = *p.Delay
+	}
+
+	if p.TransTimes != nil {
+		cmd.TransTimes = *p.TransTimes
+	}
+
+	if p.TransInterval != nil {
+		cmd.TransInterval = *p.TransInterval
+	}
+
+	if p.TransFlag != nil {
+		cmd.TransFlag = *p.TransFlag
+	}
+
+	if p.Realtime != nil {
+		cmd.Realtime = *p.Realtime
+	}
+
+	if p.Encrypt != nil {
+		cmd.Encrypt = *p.Encrypt
+	}
+
+	if p.ServerVer != nil {
+		cmd.ServerVer = *p.ServerVer
+	}
+
+	// device timestamps interpreted on same time zone
+	switch {
+	case p.TimeZone != nil:
+		cmd.TimeZone = *p.TimeZone
+		cmd.Location = fixedZoneName(*p.TimeZone)
+	case p.Location != "":
+		// validated on load
+		if loc, err := LoadDeviceLocation(p.Location); err == nil {
+			cmd.TimeZone = timeZoneOffset(loc, now)
+			cmd.Location = p.Location
+		}
+	}
+}
+
+// validate check profile which can't be applied
+func (p Profile) validate() error {
+	if p.Name == "" {
+		return ErrProfileWithoutName
+	}
+
+	if p.Pattern != "" {
+		if _, err := path.Match(p.Pattern, ""); err != nil {
+			return fmt.Errorf("Profile %s: %v", p.Name, err)
+		}
+	}
+
+	if p.Location != "" {
+		if _, err := LoadDeviceLocation(p.Location); err != nil {
+			return fmt.Errorf("Profile %s: %v", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ProfileConfig is declarative profiles of devices
+type ProfileConfig struct {
+	// applied to every device before matched profiles.
+	// nil means device which doesn't match any
+	// profile not allowed to connect
+	Default *Profile `json:"default,omitempty"`
+
+	Profiles []Profile `json:"profiles"`
+}
+
+// validate check every profile of config
+func (c ProfileConfig) validate() error {
+	if c.Default != nil {
+		if err := c.Default.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range c.Profiles {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ProfileHook implement ExchangeHook which resolve exchange
+// command from profiles. profiles loaded from file reloaded
+// upon initial exchange when file modified
+type ProfileHook struct {
+	sync.RWMutex
+
+	// registry of device groups, nil means
+	// profiles matched by group never applied
+	Registry *DeviceRegistry
+
+	config ProfileConfig
+
+	// source file and its modification time
+	path    string
+	modTime time.Time
+}
+
+// OnInitialExchange implements ExchangeHook.OnInitialExchange
+func (h *ProfileHook) OnInitialExchange(d Device) *ExchangeCommand {
+	if err := h.Reload(); err != nil {
+		log.Printf("failed to reload profiles, keep previous: %v\n", err)
+	}
+
+	return h.Resolve(d, time.Now())
+}
+
+// Resolve return exchange command of device, nil
+// when device not matched by any profile nor default
+func (h *ProfileHook) Resolve(d Device, now time.Time) *ExchangeCommand {
+	h.RLock()
+	config := h.config
+	h.RUnlock()
+
+	var groups []string
+	if h.Registry != nil {
+		if status, ok := h.Registry.Get(d.SN); ok {
+			groups = status.Groups
+		}
+	}
+
+	matched := make([]Profile, 0)
+	for _, p := range config.Profiles {
+		if p.match(d, groups) {
+			matched = append(matched, p)
+		}
+	}
+
+	if len(matched) == 0 && config.Default == nil {
+		return nil
+	}
+
+	// least specific first, keep file order on same specificity
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].specificity() < matched[j].specificity()
+	})
+
+	cmd := &ExchangeCommand{SN: d.SN}
+	if config.Default != nil {
+		config.Default.apply(cmd, now)
+	}
+
+	for _, p := range matched {
+		p.apply(cmd, now)
+	}
+
+	return cmd
+}
+
+// Reload read profiles file when modified since last load,
+// previous profiles kept when file invalid
+func (h *ProfileHook) Reload() error {
+	if h.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+
+	h.RLock()
+	modified := !info.ModTime().Equal(h.modTime)
+	h.RUnlock()
+
+	if !modified {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+
+	var config ProfileConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return err
+	}
+
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	h.Lock()
+	h.config = config
+	h.modTime = info.ModTime()
+	h.Unlock()
+
+	return nil
+}
+
+// NewProfileHook create profile hook of given profiles
+func NewProfileHook(config ProfileConfig) (*ProfileHook, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &ProfileHook{config: config}, nil
+}
+
+// LoadProfileHook create profile hook of profiles in json file
+func LoadProfileHook(path string) (*ProfileHook, error) {
+	h := &ProfileHook{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// deviceModel return device name reported on registry
+func deviceModel(d Device) string {
+	options := parseOptions([]byte(d.Option), ",")
+	if name := options["~DeviceName"]; name != "" {
+		return name
+	}
+
+	return options["DeviceName"]
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
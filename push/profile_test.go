@@ -0,0 +1,134 @@
+package push
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func intValue(v int) *int {
+	return &v
+}
+
+func TestProfileResolve(t *testing.T) {
+	h, err := NewProfileHook(ProfileConfig{
+		Default: &Profile{Name: "default", Delay: intValue(30), TimeZone: intValue(7), Realtime: intValue(1)},
+		Profiles: []Profile{
+			{Name: "single", SN: []string{"BRM0001"}, Delay: intValue(5)},
+			{Name: "branch", Pattern: "BRM*", Delay: intValue(10), Location: "Asia/Makassar"},
+			{Name: "lobby", Group: "lobby", Realtime: intValue(0)},
+			{Name: "f22", Model: "F22", TransInterval: intValue(2)},
+			{Name: "india", Pattern: "IND*", Location: "Asia/Kolkata"},
+			{Name: "newfoundland", Pattern: "NF*", TimeZone: intValue(-210)},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	h.Registry = NewDeviceRegistry(0, 0)
+	h.Registry.Register("BRM0001")
+	h.Registry.SetGroups("BRM0001", []string{"lobby"})
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+
+	// sn override pattern, pattern override default
+	cmd := h.Resolve(Device{SN: "BRM0001", Option: "~DeviceName=F22"}, now)
+	if cmd == nil || cmd.SN != "BRM0001" || cmd.Delay != 5 || cmd.TimeZone != 8 || cmd.Location != "Asia/Makassar" || cmd.Realtime != 0 || cmd.TransInterval != 2 {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	cmd = h.Resolve(Device{SN: "BRM0002"}, now)
+	if cmd == nil || cmd.Delay != 10 || cmd.Realtime != 1 || cmd.TransInterval != 0 {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	cmd = h.Resolve(Device{SN: "OTHER"}, now)
+	if cmd == nil || cmd.Delay != 30 || cmd.TimeZone != 7 || cmd.Location != "UTC+7" {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	// time zone not whole hour sent in minutes
+	cmd = h.Resolve(Device{SN: "IND01"}, now)
+	if cmd == nil || cmd.TimeZone != 330 || cmd.Location != "Asia/Kolkata" {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	cmd = h.Resolve(Device{SN: "NF01"}, now)
+	if cmd == nil || cmd.TimeZone != -210 || cmd.Location != "UTC-03:30" {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+}
+
+func TestProfileWithoutDefault(t *testing.T) {
+	h, err := NewProfileHook(ProfileConfig{
+		Profiles: []Profile{{Name: "branch", Pattern: "BRM*", Delay: intValue(10)}},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if cmd := h.OnInitialExchange(Device{SN: "OTHER"}); cmd != nil {
+		t.Errorf("expected device refused but returned %+v", cmd)
+	}
+}
+
+func TestProfileInvalidLocation(t *testing.T) {
+	_, err := NewProfileHook(ProfileConfig{
+		Profiles: []Profile{{Name: "branch", Location: "Asia/Nowhere"}},
+	})
+	if err == nil {
+		t.Error("expected invalid location error")
+	}
+}
+
+func TestProfileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "profiles.json")
+	if err := ioutil.WriteFile(path, []byte(`{"default": {"name": "default", "delay": 30}}`), 0644); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	h, err := LoadProfileHook(path)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if cmd := h.OnInitialExchange(Device{SN: "BRM0001"}); cmd == nil || cmd.Delay != 30 {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	// modified file picked up on next exchange
+	if err := ioutil.WriteFile(path, []byte(`{"default": {"name": "default", "delay": 15}}`), 0644); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	if cmd := h.OnInitialExchange(Device{SN: "BRM0001"}); cmd == nil || cmd.Delay != 15 {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+
+	// invalid file doesn't replace loaded profiles
+	if err := ioutil.WriteFile(path, []byte(`{"default": `), 0644); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+
+	if cmd := h.OnInitialExchange(Device{SN: "BRM0001"}); cmd == nil || cmd.Delay != 15 {
+		t.Errorf("unexpected exchange command %+v", cmd)
+	}
+}
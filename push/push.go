@@ -11,6 +11,31 @@ var (
 	ErrPayloadIsNil        = errors.New("Payload value is nil")
 	ErrReceiverInvalid     = errors.New("Receiver doesn't implement correct payload interface")
 	ErrDeviceNotRegistered = errors.New("Device not registered")
+
+	ErrCommandExpired        = errors.New("Command expired")
+	ErrCommandUnacknowledged = errors.New("Command not acknowledged by device")
+)
+
+// command result error
+var (
+	ErrParameterIncorrect      = errors.New("Parameter is incorrect")
+	ErrUserPhotoSizeMismatch   = errors.New("User photo data doesn't match the given size")
+	ErrReadingWritingIncorrect = errors.New("Reading or writing is incorrect")
+	ErrTemplateSizeMismatch    = errors.New("Template data doesn't match the given size")
+	ErrUserPINNotExists        = errors.New("User PIN doesn't exist")
+	ErrLimitedCapacity         = errors.New("Limited capacity")
+	ErrNotSupported            = errors.New("Not supported by equipment")
+	ErrCommandTimeout          = errors.New("Command execution timeout")
+	ErrEquipmentIsBusy         = errors.New("Equipment is busy")
+	ErrDataTooLong             = errors.New("Data is too long")
+
+	ErrFingerPrintExists                     = errors.New("Finger print of user already exists")
+	ErrFingerPrintEnrollmentFailed           = errors.New("Finger print enrollment failed")
+	ErrFingerPrintExistsInDatabase           = errors.New("Enrolled finger print already exists in database")
+	ErrFingerPrintEnrollmentCanceled         = errors.New("Finger print enrollment canceled")
+	ErrFingerPrintEnrollmentFailedDeviceBusy = errors.New("Finger print enrollment failed, equipment is busy")
+	ErrIllegalFingerPrintFormat              = errors.New("Finger print format is illegal")
+	ErrIllegalFingerPrintTemplate            = errors.New("Finger print template is illegal")
 )
 
 // static value
@@ -0,0 +1,157 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// number of uploaded records buffered while collecting query result
+const queryBuffer = 256
+
+// query send data query command, then collect records uploaded
+// by device until command result received. device upload queried
+// records through regular upload, so they also reach hooks and
+// event subscribers. upload observed on this server's event bus,
+// device should poll same replica when state shared
+func (s *Server) query(ctx context.Context, sn string, cmd Command, eventType string, collect func(Event)) error {
+	sub := s.events.SubscribeLossless(EventFilter{SN: []string{sn}, Types: []string{eventType}}, queryBuffer)
+	defer sub.Close()
+
+	type result struct {
+		resp CommandResponse
+		err  error
+	}
+
+	resultc := make(chan result, 1)
+	go func() {
+		resp, err := s.DoContext(ctx, sn, cmd)
+		resultc <- result{resp, err}
+	}()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return context.Canceled
+			}
+
+			collect(e)
+		case r := <-resultc:
+			if r.err != nil {
+				return r.err
+			}
+
+			if err := r.resp.Err(); err != nil {
+				return err
+			}
+
+			// upload completed before result, take the rest
+			for {
+				select {
+				case e := <-sub.C:
+					collect(e)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// QueryAttendance pull attendance logs of given period from device,
+// including those which already uploaded before
+func (s *Server) QueryAttendance(ctx context.Context, sn string, from, to time.Time) ([]AttendanceLog, error) {
+	// period written as read by device clock
+	loc := s.location(sn)
+
+	cmd := Command{
+		CMD:     "DATA QUERY ATTLOG",
+		Payload: []byte(fmt.Sprintf("StartTime=%s\tEndTime=%s", from.In(loc).Format(logTimeLayout), to.In(loc).Format(logTimeLayout))),
+	}
+
+	var (
+		logs = make([]AttendanceLog, 0)
+		seen = make(map[string]bool)
+	)
+
+	err := s.query(ctx, sn, cmd, EventAttendance, func(e Event) {
+		record, ok := e.Data.(AttendanceLog)
+		if !ok || record.Time.Before(from) || record.Time.After(to) {
+			return
+		}
+
+		// same record may be uploaded by regular upload
+		key := record.PIN + record.Time.String()
+		if !seen[key] {
+			seen[key] = true
+			logs = append(logs, record)
+		}
+	})
+
+	return logs, err
+}
+
+// QueryUsers pull user information from device,
+// empty pin means all users
+func (s *Server) QueryUsers(ctx context.Context, sn string, pin string) ([]User, error) {
+	cmd := Command{CMD: "DATA QUERY USERINFO"}
+	if pin != "" {
+		cmd.Payload = []byte("PIN=" + pin)
+	}
+
+	var (
+		users = make([]User, 0)
+		index = make(map[string]int)
+	)
+
+	err := s.query(ctx, sn, cmd, EventUser, func(e Event) {
+		user, ok := e.Data.(User)
+		if !ok || (pin != "" && user.PIN != pin) {
+			return
+		}
+
+		// keep latest information of user
+		if i, ok := index[user.PIN]; ok {
+			users[i] = user
+			return
+		}
+
+		index[user.PIN] = len(users)
+		users = append(users, user)
+	})
+
+	return users, err
+}
+
+// QueryTemplates pull finger print templates from device,
+// empty pin means templates of all users
+func (s *Server) QueryTemplates(ctx context.Context, sn string, pin string) ([]FingerPrint, error) {
+	cmd := Command{CMD: "DATA QUERY FINGERTMP"}
+	if pin != "" {
+		cmd.Payload = []byte("PIN=" + pin)
+	}
+
+	var (
+		templates = make([]FingerPrint, 0)
+		index     = make(map[string]int)
+	)
+
+	err := s.query(ctx, sn, cmd, EventFingerPrint, func(e Event) {
+		fp, ok := e.Data.(FingerPrint)
+		if !ok || (pin != "" && fp.PIN != pin) {
+			return
+		}
+
+		key := fmt.Sprintf("%s:%d", fp.PIN, fp.FID)
+		if i, ok := index[key]; ok {
+			templates[i] = fp
+			return
+		}
+
+		index[key] = len(templates)
+		templates = append(templates, fp)
+	})
+
+	return templates, err
+}
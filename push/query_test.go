@@ -0,0 +1,94 @@
+package push
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryAttendance(t *testing.T) {
+	s := NewServer(&ServerOption{DeviceLocation: time.FixedZone("UTC+7", 7*3600)})
+	s.RegisterDevice("123456789")
+
+	// period given in other location than device clock
+	from := time.Date(2019, 5, 19, 17, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 5, 20, 16, 59, 59, 0, time.UTC)
+
+	type result struct {
+		logs []AttendanceLog
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		logs, err := s.QueryAttendance(ctx, "123456789", from, to)
+		done <- result{logs, err}
+	}()
+
+	polled := pollCommand(s, "123456789")
+	if !strings.Contains(polled, "DATA QUERY ATTLOG StartTime=2019-05-20 00:00:00\tEndTime=2019-05-20 23:59:59") {
+		t.Errorf("unexpected command %s", polled)
+		t.FailNow()
+	}
+
+	body := "1\t2019-05-20 08:00:00\t0\t1\n" +
+		"2\t2019-05-20 08:05:00\t0\t1\n" +
+		"1\t2019-05-20 08:00:00\t0\t1\n" +
+		"3\t2019-05-21 08:00:00\t0\t1\n"
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=ATTLOG", strings.NewReader(body)))
+
+	respondCommand(s, "123456789", polled, "0")
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if len(r.logs) != 2 || r.logs[0].PIN != "1" || r.logs[1].PIN != "2" {
+		t.Errorf("unexpected attendance logs %+v", r.logs)
+	}
+}
+
+func TestQueryUsers(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	type result struct {
+		users []User
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		users, err := s.QueryUsers(context.Background(), "123456789", "")
+		done <- result{users, err}
+	}()
+
+	polled := pollCommand(s, "123456789")
+
+	body := "USER PIN=1\tName=Alice\tPri=14\tPasswd=\tCard=\tGrp=1\tTZ=0000000100000000\n" +
+		"USER PIN=2\tName=Bob\tPri=0\tPasswd=\tCard=123\tGrp=1\tTZ=0000000100000000\n"
+
+	w := httptest.NewRecorder()
+	s.handleUpload(w, httptest.NewRequest("POST", "/iclock/cdata?SN=123456789&table=OPERLOG", strings.NewReader(body)))
+
+	respondCommand(s, "123456789", polled, "0")
+
+	r := <-done
+	if r.err != nil {
+		t.Error(r.err)
+		t.FailNow()
+	}
+
+	if len(r.users) != 2 || r.users[0].Name != "Alice" || r.users[0].Privilege != 14 || r.users[1].Card != "123" {
+		t.Errorf("unexpected users %+v", r.users)
+	}
+}
@@ -0,0 +1,483 @@
+package push
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// default presence setting
+const (
+	defaultOfflineMultiplier = 3
+	defaultOfflineTimeout    = time.Minute * 2
+)
+
+// PresenceHook define callback upon device presence changes
+type PresenceHook interface {
+	// called when device start communicating after
+	// registered or being offline
+	OnDeviceOnline(d DeviceStatus)
+
+	// called when device silent longer than allowed
+	OnDeviceOffline(d DeviceStatus)
+}
+
+// DeviceStatus describe last known state of a device
+type DeviceStatus struct {
+	Device
+
+	// remote address of last request
+	IP string `json:"ip"`
+
+	// device request interval in seconds,
+	// taken from initial exchange
+	Delay int `json:"delay"`
+
+	// last request time, zero if never seen
+	LastSeen time.Time `json:"last_seen"`
+
+	Online bool `json:"online"`
+
+	// whether payload encryption negotiated
+	Encrypted bool `json:"encrypted"`
+
+	// last reported device information
+	Info DeviceInfo `json:"info"`
+
+	// groups device belongs to, assigned by operator
+	Groups []string `json:"groups"`
+
+	// last attendance log upload
+	LastUpload time.Time `json:"last_upload"`
+
+	// location of device clock, defined on exchange
+	Location string `json:"location"`
+}
+
+// fields of device entry, each stored separately so concurrent
+// replicas updating different fields don't overwrite each other
+const (
+	fieldDevice       = "device"
+	fieldCapabilities = "capabilities"
+	fieldIP           = "ip"
+	fieldDelay        = "delay"
+	fieldLastSeen     = "last_seen"
+	fieldEncrypted    = "encrypted"
+	fieldInfo         = "info"
+	fieldGroups       = "groups"
+	fieldLastUpload   = "last_upload"
+	fieldLocation     = "location"
+
+	// present on every registered device, so updates of
+	// unknown device skipped within single operation
+	fieldRegistered = "registered"
+
+	// present while device offline, removed by single
+	// replica which see device became online
+	fieldOffline = "offline"
+)
+
+// DeviceRegistry keep track registered devices
+// and their presence
+type DeviceRegistry struct {
+	mu sync.RWMutex
+
+	// storage of devices, in memory unless shared.
+	// registered serial numbers kept in hash of name,
+	// fields of each device in hash of name:sn
+	store queue.Store
+	name  string
+
+	// device considered offline after silent
+	// for multiplier * delay seconds
+	multiplier int
+
+	// silence allowed for device with unknown delay
+	timeout time.Duration
+}
+
+// NewDeviceRegistry create empty registry. device considered offline
+// after silent for multiplier times its delay, or timeout when delay
+// is unknown
+func NewDeviceRegistry(multiplier int, timeout time.Duration) *DeviceRegistry {
+	if multiplier < 1 {
+		multiplier = defaultOfflineMultiplier
+	}
+
+	if timeout <= 0 {
+		timeout = defaultOfflineTimeout
+	}
+
+	return &DeviceRegistry{
+		store:      queue.NewStore(),
+		name:       "devices",
+		multiplier: multiplier,
+		timeout:    timeout,
+	}
+}
+
+// share keep devices in given store instead of memory,
+// so they visible to other server replicas
+func (r *DeviceRegistry) share(store queue.Store, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store = store
+	r.name = name
+}
+
+// storage return store and index hash name of devices
+func (r *DeviceRegistry) storage() (queue.Store, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.store, r.name
+}
+
+// exists check whether device registered
+func (r *DeviceRegistry) exists(sn string) bool {
+	store, name := r.storage()
+
+	_, err := store.Get(name+":"+sn, fieldRegistered)
+	if err != nil && err != queue.ErrKeyNotFound {
+		log.Printf("failed to load device %s: %v\n", sn, err)
+	}
+
+	return err == nil
+}
+
+// encodeField encode value of device field
+func encodeField(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+
+	return string(b), err
+}
+
+// set store single field of device
+func (r *DeviceRegistry) set(sn string, field string, v interface{}) {
+	store, name := r.storage()
+
+	value, err := encodeField(v)
+	if err == nil {
+		err = store.Set(name+":"+sn, field, value)
+	}
+
+	if err != nil {
+		log.Printf("failed to save %s of device %s: %v\n", field, sn, err)
+	}
+}
+
+// field decode single field of device, false when not set
+func (r *DeviceRegistry) field(sn string, field string, v interface{}) bool {
+	store, name := r.storage()
+
+	s, err := store.Get(name+":"+sn, field)
+	if err != nil {
+		if err != queue.ErrKeyNotFound {
+			log.Printf("failed to load %s of device %s: %v\n", field, sn, err)
+		}
+
+		return false
+	}
+
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		log.Printf("failed to decode %s of device %s: %v\n", field, sn, err)
+		return false
+	}
+
+	return true
+}
+
+// load return status of registered device
+func (r *DeviceRegistry) load(sn string) (DeviceStatus, bool) {
+	store, name := r.storage()
+
+	fields, err := store.All(name + ":" + sn)
+	if err != nil {
+		log.Printf("failed to load device %s: %v\n", sn, err)
+		return DeviceStatus{}, false
+	}
+
+	if _, ok := fields[fieldRegistered]; !ok {
+		return DeviceStatus{}, false
+	}
+
+	return decodeStatus(sn, fields), true
+}
+
+// decodeStatus build status of device from its stored fields
+func decodeStatus(sn string, fields map[string]string) DeviceStatus {
+	status := DeviceStatus{Device: Device{SN: sn}}
+	decode := func(field string, v interface{}) {
+		if s, ok := fields[field]; ok {
+			if err := json.Unmarshal([]byte(s), v); err != nil {
+				log.Printf("failed to decode %s of device %s: %v\n", field, sn, err)
+			}
+		}
+	}
+
+	decode(fieldDevice, &status.Device)
+	decode(fieldCapabilities, &status.Capabilities)
+	decode(fieldIP, &status.IP)
+	decode(fieldDelay, &status.Delay)
+	decode(fieldLastSeen, &status.LastSeen)
+	decode(fieldEncrypted, &status.Encrypted)
+	decode(fieldInfo, &status.Info)
+	decode(fieldGroups, &status.Groups)
+	decode(fieldLastUpload, &status.LastUpload)
+	decode(fieldLocation, &status.Location)
+
+	_, offline := fields[fieldOffline]
+	status.Online = !offline
+
+	return status
+}
+
+// all return stored fields of every registered device by serial number
+func (r *DeviceRegistry) all() map[string]map[string]string {
+	store, name := r.storage()
+
+	index, err := store.All(name)
+	if err != nil {
+		log.Printf("failed to load devices: %v\n", err)
+		return nil
+	}
+
+	devices := make(map[string]map[string]string, len(index))
+	for sn := range index {
+		fields, err := store.All(name + ":" + sn)
+		if err != nil {
+			log.Printf("failed to load device %s: %v\n", sn, err)
+			continue
+		}
+
+		if _, ok := fields[fieldRegistered]; ok {
+			devices[sn] = fields
+		}
+	}
+
+	return devices
+}
+
+// Register add device without waiting it communicate
+func (r *DeviceRegistry) Register(sn string) {
+	if r.exists(sn) {
+		return
+	}
+
+	store, name := r.storage()
+
+	// offline until seen
+	r.set(sn, fieldOffline, true)
+	r.set(sn, fieldRegistered, true)
+	if err := store.Set(name, sn, "1"); err != nil {
+		log.Printf("failed to register device %s: %v\n", sn, err)
+	}
+}
+
+// Update store device info sent on initial exchange
+func (r *DeviceRegistry) Update(d Device, delay int) {
+	r.Register(d.SN)
+
+	var info DeviceInfo
+	r.field(d.SN, fieldInfo, &info)
+
+	// comm key checked on request, never kept, so it
+	// doesn't leak through events, webhooks and store
+	d.PushCommKey = ""
+	d.Capabilities = ParseCapabilities(d.Option, info)
+
+	r.set(d.SN, fieldDevice, d)
+	r.set(d.SN, fieldCapabilities, d.Capabilities)
+	r.set(d.SN, fieldDelay, delay)
+}
+
+// SetLocation store location of device clock
+func (r *DeviceRegistry) SetLocation(sn string, location string) {
+	r.Register(sn)
+	r.set(sn, fieldLocation, location)
+}
+
+// SetEncrypted store negotiated encryption of device
+func (r *DeviceRegistry) SetEncrypted(sn string, encrypted bool) {
+	r.Register(sn)
+	r.set(sn, fieldEncrypted, encrypted)
+}
+
+// SetInfo store reported information of registered device
+func (r *DeviceRegistry) SetInfo(sn string, info DeviceInfo) {
+	if !r.exists(sn) {
+		return
+	}
+
+	var d Device
+	r.field(sn, fieldDevice, &d)
+
+	r.set(sn, fieldInfo, info)
+	r.set(sn, fieldCapabilities, ParseCapabilities(d.Option, info))
+}
+
+// Uploaded mark registered device just uploaded attendance logs
+func (r *DeviceRegistry) Uploaded(sn string, now time.Time) {
+	if r.exists(sn) {
+		r.set(sn, fieldLastUpload, now)
+	}
+}
+
+// SetGroups replace groups of registered device,
+// returns false if device unknown
+func (r *DeviceRegistry) SetGroups(sn string, groups []string) bool {
+	if !r.exists(sn) {
+		return false
+	}
+
+	r.set(sn, fieldGroups, append([]string(nil), groups...))
+
+	return true
+}
+
+// Members return devices of given group ordered by serial number
+func (r *DeviceRegistry) Members(group string) []DeviceStatus {
+	var list []DeviceStatus
+	for _, status := range r.List() {
+		for _, g := range status.Groups {
+			if g == group {
+				list = append(list, status)
+				break
+			}
+		}
+	}
+
+	return list
+}
+
+// Touch mark registered device as seen, returns true if device
+// just became online, reported by single caller even across
+// replicas. unknown device is ignored
+func (r *DeviceRegistry) Touch(sn string, ip string, now time.Time) (DeviceStatus, bool) {
+	update := queue.HashUpdate{
+		Require: fieldRegistered,
+		Set:     make(map[string]string),
+		Delete:  []string{fieldOffline},
+	}
+
+	update.Set[fieldLastSeen], _ = encodeField(now)
+	if ip != "" {
+		update.Set[fieldIP], _ = encodeField(ip)
+	}
+
+	// single operation, so expiry can't interleave
+	store, name := r.storage()
+	before, applied, err := store.Update(name+":"+sn, update)
+	if err != nil {
+		log.Printf("failed to mark device %s online: %v\n", sn, err)
+		return DeviceStatus{}, false
+	}
+
+	if !applied {
+		return DeviceStatus{}, false
+	}
+
+	status := decodeStatus(sn, before)
+	status.LastSeen = now
+	if ip != "" {
+		status.IP = ip
+	}
+
+	_, offline := before[fieldOffline]
+	status.Online = true
+
+	return status, offline
+}
+
+// Get return status of given device
+func (r *DeviceRegistry) Get(sn string) (DeviceStatus, bool) {
+	return r.load(sn)
+}
+
+// List return status of all devices ordered by serial number
+func (r *DeviceRegistry) List() []DeviceStatus {
+	devices := r.all()
+
+	list := make([]DeviceStatus, 0, len(devices))
+	for sn, fields := range devices {
+		list = append(list, decodeStatus(sn, fields))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].SN < list[j].SN
+	})
+
+	return list
+}
+
+// Offline return devices which currently offline,
+// including those which never seen
+func (r *DeviceRegistry) Offline() []DeviceStatus {
+	var list []DeviceStatus
+	for _, status := range r.List() {
+		if !status.Online {
+			list = append(list, status)
+		}
+	}
+
+	return list
+}
+
+// silence return allowed silence duration of device
+func (r *DeviceRegistry) silence(status DeviceStatus) time.Duration {
+	if status.Delay <= 0 {
+		return r.timeout
+	}
+
+	return time.Duration(status.Delay*r.multiplier) * time.Second
+}
+
+// expire mark silent devices offline, return devices which just
+// became offline. should run on single replica, see Server.lead
+func (r *DeviceRegistry) expire(now time.Time) []DeviceStatus {
+	store, name := r.storage()
+
+	var offline []DeviceStatus
+	for sn, fields := range r.all() {
+		status := decodeStatus(sn, fields)
+		if !status.Online || now.Sub(status.LastSeen) < r.silence(status) {
+			continue
+		}
+
+		// skipped when device seen since loaded
+		marker, _ := encodeField(true)
+		_, applied, err := store.Update(name+":"+sn, queue.HashUpdate{
+			Match: map[string]string{fieldLastSeen: fields[fieldLastSeen]},
+			Set:   map[string]string{fieldOffline: marker},
+		})
+		if err != nil {
+			log.Printf("failed to mark device %s offline: %v\n", sn, err)
+			continue
+		}
+
+		if !applied {
+			continue
+		}
+
+		status.Online = false
+		offline = append(offline, status)
+	}
+
+	return offline
+}
+
+// remoteIP extract ip address from request remote address
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
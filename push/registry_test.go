@@ -0,0 +1,205 @@
+package push
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// countingStore count store operations and run hook once
+// loaded fields of device, e.g. to interleave request
+type countingStore struct {
+	queue.Store
+
+	calls  int
+	onLoad func()
+}
+
+func (s *countingStore) Get(name string, key string) (string, error) {
+	s.calls++
+	return s.Store.Get(name, key)
+}
+
+func (s *countingStore) Set(name string, key string, value string) error {
+	s.calls++
+	return s.Store.Set(name, key, value)
+}
+
+func (s *countingStore) Delete(name string, key string) (bool, error) {
+	s.calls++
+	return s.Store.Delete(name, key)
+}
+
+func (s *countingStore) All(name string) (map[string]string, error) {
+	s.calls++
+	all, err := s.Store.All(name)
+
+	if hook := s.onLoad; hook != nil && strings.Contains(name, ":") {
+		s.onLoad = nil
+		hook()
+	}
+
+	return all, err
+}
+
+func (s *countingStore) Update(name string, update queue.HashUpdate) (map[string]string, bool, error) {
+	s.calls++
+	return s.Store.Update(name, update)
+}
+
+func TestRegistryPresence(t *testing.T) {
+	r := NewDeviceRegistry(3, time.Minute)
+	r.Register("123456789")
+	r.Update(Device{SN: "123456789"}, 10)
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+
+	// unknown device ignored
+	if _, online := r.Touch("987654321", "10.0.0.2", now); online {
+		t.Error("expected unknown device ignored")
+		t.FailNow()
+	}
+
+	if _, ok := r.Get("987654321"); ok {
+		t.Error("expected unknown device not registered")
+		t.FailNow()
+	}
+
+	status, online := r.Touch("123456789", "10.0.0.1", now)
+	if !online || !status.Online || status.IP != "10.0.0.1" || !status.LastSeen.Equal(now) {
+		t.Errorf("expected device just became online but returned %+v", status)
+		t.FailNow()
+	}
+
+	// already online
+	if _, online := r.Touch("123456789", "", now.Add(time.Second*10)); online {
+		t.Error("expected device stay online")
+		t.FailNow()
+	}
+
+	if status, _ := r.Get("123456789"); status.IP != "10.0.0.1" {
+		t.Errorf("expected ip kept when not sent but returned %s", status.IP)
+		t.FailNow()
+	}
+
+	// silent less than multiplier * delay
+	if offline := r.expire(now.Add(time.Second * 39)); len(offline) != 0 {
+		t.Errorf("expected device still online but returned %+v", offline)
+		t.FailNow()
+	}
+
+	offline := r.expire(now.Add(time.Second * 40))
+	if len(offline) != 1 || offline[0].SN != "123456789" || offline[0].Online {
+		t.Errorf("expected device became offline but returned %+v", offline)
+		t.FailNow()
+	}
+
+	// reported once
+	if offline := r.expire(now.Add(time.Minute)); len(offline) != 0 {
+		t.Errorf("expected device offline reported once but returned %+v", offline)
+		t.FailNow()
+	}
+
+	if list := r.Offline(); len(list) != 1 {
+		t.Errorf("expected single offline device but returned %+v", list)
+		t.FailNow()
+	}
+
+	if _, online := r.Touch("123456789", "10.0.0.1", now.Add(time.Minute)); !online {
+		t.Error("expected device back online")
+	}
+}
+
+func TestRegistrySilence(t *testing.T) {
+	r := NewDeviceRegistry(0, 0)
+
+	if d := r.silence(DeviceStatus{Delay: 30}); d != time.Duration(30*defaultOfflineMultiplier)*time.Second {
+		t.Errorf("expected silence of default multiplier but returned %v", d)
+	}
+
+	// delay unknown
+	if d := r.silence(DeviceStatus{}); d != defaultOfflineTimeout {
+		t.Errorf("expected fallback timeout but returned %v", d)
+	}
+
+	r = NewDeviceRegistry(2, time.Second*30)
+	r.Register("123456789")
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+	r.Touch("123456789", "", now)
+
+	if offline := r.expire(now.Add(time.Second * 29)); len(offline) != 0 {
+		t.Errorf("expected device still online but returned %+v", offline)
+		t.FailNow()
+	}
+
+	if offline := r.expire(now.Add(time.Second * 30)); len(offline) != 1 {
+		t.Errorf("expected device offline after fallback timeout but returned %+v", offline)
+	}
+}
+
+func TestRegistryWithoutCommKey(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+	s.Registry().Update(Device{SN: "123456789", PushCommKey: "secret"}, 10)
+
+	sub := s.Events().Subscribe(EventFilter{Types: []string{EventDeviceOnline}}, 1)
+	defer sub.Close()
+
+	status, online := s.Registry().Touch("123456789", "10.0.0.1", time.Now())
+	if !online || status.PushCommKey != "" {
+		t.Errorf("expected comm key not kept but returned %+v", status)
+		t.FailNow()
+	}
+
+	s.notifyPresence(status)
+
+	b, err := json.Marshal(<-sub.C)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), "PushCommKey") {
+		t.Errorf("expected comm key hidden but published %s", b)
+	}
+}
+
+func TestRegistryTouchSingleOperation(t *testing.T) {
+	store := &countingStore{Store: queue.NewStore()}
+
+	r := NewDeviceRegistry(3, time.Minute)
+	r.share(store, "devices")
+	r.Register("123456789")
+	r.Update(Device{SN: "123456789"}, 10)
+
+	now := time.Date(2019, 5, 20, 8, 0, 0, 0, time.UTC)
+
+	store.calls = 0
+	if status, online := r.Touch("123456789", "10.0.0.1", now); !online || status.IP != "10.0.0.1" || status.Delay != 10 {
+		t.Errorf("expected device just became online but returned %+v", status)
+		t.FailNow()
+	}
+
+	if store.calls != 1 {
+		t.Errorf("expected single store operation but called %d", store.calls)
+		t.FailNow()
+	}
+
+	// device poll while expiry loading its fields
+	store.onLoad = func() {
+		r.Touch("123456789", "", now.Add(time.Minute))
+	}
+
+	if offline := r.expire(now.Add(time.Minute)); len(offline) != 0 {
+		t.Errorf("expected device seen during expiry kept online but returned %+v", offline)
+		t.FailNow()
+	}
+
+	if status, _ := r.Get("123456789"); !status.Online {
+		t.Errorf("expected device online but returned %+v", status)
+	}
+}
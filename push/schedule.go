@@ -0,0 +1,509 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// schedule related error
+var (
+	ErrScheduleNotFound  = errors.New("Schedule not found")
+	ErrSchedulerDisabled = errors.New("Scheduler is not enabled")
+)
+
+// interval of checking due schedules
+var scheduleCheckInterval = time.Second * 10
+
+// OfflinePolicy define scheduled run of offline device
+type OfflinePolicy string
+
+// offline policies
+const (
+	// don't queue commands, run recorded as skipped
+	OfflineSkip OfflinePolicy = "skip"
+
+	// queue commands anyway, sent once device back online
+	// unless expired
+	OfflineQueue OfflinePolicy = "queue"
+)
+
+// ScheduleCommand is command issued on each run
+type ScheduleCommand struct {
+	CMD      string          `json:"cmd"`
+	Payload  string          `json:"payload,omitempty"`
+	Priority CommandPriority `json:"priority"`
+}
+
+// Schedule define commands which queued on cron like schedule
+// for devices or device group
+type Schedule struct {
+	ID string `json:"id"`
+
+	// five fields cron expression in server local time,
+	// e.g. "0 2 * * *", or descriptor e.g. "@hourly"
+	Spec string `json:"spec"`
+
+	// target devices, either listed or members of group
+	SN    []string `json:"sn,omitempty"`
+	Group string   `json:"group,omitempty"`
+
+	Commands []ScheduleCommand `json:"commands"`
+
+	// run of offline device, default skip
+	Offline OfflinePolicy `json:"offline,omitempty"`
+
+	// skip device which doesn't upload attendance logs within
+	// given seconds, e.g. before clearing logs. zero means no check
+	RequireUploadSeconds int `json:"require_upload_seconds,omitempty"`
+
+	// queued commands expired when not delivered within ttl
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// ScheduleStore persist schedules
+type ScheduleStore interface {
+	// Save insert or replace schedule with same id
+	Save(schedule Schedule) error
+
+	// Delete remove schedule by id
+	Delete(id string) error
+
+	// List return all schedules ordered by id
+	List() ([]Schedule, error)
+}
+
+// InMemoryScheduleStore implement ScheduleStore using memory
+type InMemoryScheduleStore struct {
+	sync.Mutex
+
+	schedules map[string]Schedule
+}
+
+// Save implements ScheduleStore.Save
+func (s *InMemoryScheduleStore) Save(schedule Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.schedules[schedule.ID] = schedule
+
+	return nil
+}
+
+// Delete implements ScheduleStore.Delete
+func (s *InMemoryScheduleStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+
+	delete(s.schedules, id)
+
+	return nil
+}
+
+// List implements ScheduleStore.List
+func (s *InMemoryScheduleStore) List() ([]Schedule, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return sortSchedules(s.schedules), nil
+}
+
+// NewScheduleStore create in memory schedule store
+func NewScheduleStore() *InMemoryScheduleStore {
+	return &InMemoryScheduleStore{schedules: make(map[string]Schedule)}
+}
+
+// FileScheduleStore implement ScheduleStore using json file
+type FileScheduleStore struct {
+	sync.Mutex
+
+	path      string
+	schedules map[string]Schedule
+}
+
+// Save implements ScheduleStore.Save
+func (s *FileScheduleStore) Save(schedule Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.schedules[schedule.ID] = schedule
+
+	return s.save()
+}
+
+// Delete implements ScheduleStore.Delete
+func (s *FileScheduleStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+
+	delete(s.schedules, id)
+
+	return s.save()
+}
+
+// List implements ScheduleStore.List
+func (s *FileScheduleStore) List() ([]Schedule, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return sortSchedules(s.schedules), nil
+}
+
+// save write schedules into file. caller must hold lock
+func (s *FileScheduleStore) save() error {
+	b, err := json.MarshalIndent(sortSchedules(s.schedules), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, b)
+}
+
+// NewFileScheduleStore open schedule store backed by given file.
+// file created on first update if not exists
+func NewFileScheduleStore(path string) (*FileScheduleStore, error) {
+	s := &FileScheduleStore{
+		path:      path,
+		schedules: make(map[string]Schedule),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []Schedule
+	if err := json.Unmarshal(b, &schedules); err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		s.schedules[schedule.ID] = schedule
+	}
+
+	return s, nil
+}
+
+// SharedScheduleStore implement ScheduleStore using hash of shared
+// state store, so schedules managed on any replica
+type SharedScheduleStore struct {
+	store queue.Store
+	name  string
+}
+
+// Save implements ScheduleStore.Save
+func (s *SharedScheduleStore) Save(schedule Schedule) error {
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Set(s.name, schedule.ID, string(b))
+}
+
+// Delete implements ScheduleStore.Delete
+func (s *SharedScheduleStore) Delete(id string) error {
+	ok, err := s.store.Delete(s.name, id)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrScheduleNotFound
+	}
+
+	return nil
+}
+
+// List implements ScheduleStore.List
+func (s *SharedScheduleStore) List() ([]Schedule, error) {
+	all, err := s.store.All(s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make(map[string]Schedule, len(all))
+	for id, v := range all {
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(v), &schedule); err != nil {
+			log.Printf("failed to decode schedule %s: %v\n", id, err)
+			continue
+		}
+
+		schedules[id] = schedule
+	}
+
+	return sortSchedules(schedules), nil
+}
+
+// NewSharedScheduleStore create schedule store on shared state
+func NewSharedScheduleStore(state *SharedState) *SharedScheduleStore {
+	return &SharedScheduleStore{store: state.Store, name: state.key("schedules")}
+}
+
+func sortSchedules(schedules map[string]Schedule) []Schedule {
+	list := make([]Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		list = append(list, schedule)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// Scheduler queue commands of due schedules. when server replicas
+// share state, due schedules run by single elected replica
+type Scheduler struct {
+	server *Server
+	store  ScheduleStore
+
+	// next run time of each schedule
+	lock sync.Mutex
+	next map[string]time.Time
+}
+
+// NewScheduler create scheduler of server, schedules kept in
+// given store. default in memory
+func NewScheduler(s *Server, store ScheduleStore) *Scheduler {
+	if store == nil {
+		store = NewScheduleStore()
+	}
+
+	return &Scheduler{
+		server: s,
+		store:  store,
+		next:   make(map[string]time.Time),
+	}
+}
+
+// Add validate and save schedule, id generated when empty
+func (s *Scheduler) Add(schedule Schedule) (Schedule, error) {
+	if _, err := parseCron(schedule.Spec); err != nil {
+		return Schedule{}, err
+	}
+
+	if len(schedule.Commands) == 0 {
+		return Schedule{}, ErrEmptyCommand
+	}
+
+	for _, c := range schedule.Commands {
+		if err := validRawCommand(c.CMD, c.Payload); err != nil {
+			return Schedule{}, err
+		}
+	}
+
+	if schedule.ID == "" {
+		schedule.ID = randomCommandID()
+	}
+
+	if schedule.Offline == "" {
+		schedule.Offline = OfflineSkip
+	}
+
+	if err := s.store.Save(schedule); err != nil {
+		return Schedule{}, err
+	}
+
+	// recalculate on next check
+	s.lock.Lock()
+	delete(s.next, schedule.ID)
+	s.lock.Unlock()
+
+	return schedule, nil
+}
+
+// Remove delete schedule
+func (s *Scheduler) Remove(id string) error {
+	return s.store.Delete(id)
+}
+
+// List return all schedules
+func (s *Scheduler) List() ([]Schedule, error) {
+	return s.store.List()
+}
+
+// Run check due schedules until context cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+// check run schedules which due at given time
+func (s *Scheduler) check(now time.Time) {
+	schedules, err := s.store.List()
+	if err != nil {
+		log.Printf("failed to load schedules: %v\n", err)
+		return
+	}
+
+	// every replica track next run, so
+	// other replica can take over leader
+	lead := s.server.lead("scheduler", scheduleCheckInterval)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := make(map[string]time.Time, len(schedules))
+	for _, schedule := range schedules {
+		if schedule.Disabled {
+			continue
+		}
+
+		spec, err := parseCron(schedule.Spec)
+		if err != nil {
+			log.Printf("invalid spec of schedule %s: %v\n", schedule.ID, err)
+			continue
+		}
+
+		// first seen, wait for next occurrence
+		due, ok := s.next[schedule.ID]
+		if !ok {
+			next[schedule.ID] = spec.next(now)
+			continue
+		}
+
+		if due.IsZero() || now.Before(due) {
+			next[schedule.ID] = due
+			continue
+		}
+
+		if lead {
+			s.run(schedule, now)
+		}
+		next[schedule.ID] = spec.next(now)
+	}
+
+	// drop removed schedules
+	s.next = next
+}
+
+// targets return serial number of schedule devices
+func (s *Scheduler) targets(schedule Schedule) []string {
+	seen := make(map[string]bool)
+
+	var targets []string
+	for _, sn := range schedule.SN {
+		if !seen[sn] {
+			seen[sn] = true
+			targets = append(targets, sn)
+		}
+	}
+
+	if schedule.Group != "" {
+		for _, status := range s.server.registry.Members(schedule.Group) {
+			if !seen[status.SN] {
+				seen[status.SN] = true
+				targets = append(targets, status.SN)
+			}
+		}
+	}
+
+	return targets
+}
+
+// skipReason return why schedule shouldn't run on device,
+// empty if it should
+func (s *Scheduler) skipReason(schedule Schedule, sn string, now time.Time) string {
+	status, ok := s.server.registry.Get(sn)
+	if !ok {
+		return ErrDeviceNotRegistered.Error()
+	}
+
+	if !status.Online && schedule.Offline != OfflineQueue {
+		return "device offline"
+	}
+
+	if schedule.RequireUploadSeconds > 0 {
+		within := time.Duration(schedule.RequireUploadSeconds) * time.Second
+		if status.LastUpload.IsZero() || now.Sub(status.LastUpload) > within {
+			return "attendance upload not confirmed"
+		}
+	}
+
+	return ""
+}
+
+// run queue schedule commands for its devices, each
+// queued or skipped command recorded in command history
+func (s *Scheduler) run(schedule Schedule, now time.Time) {
+	for _, sn := range s.targets(schedule) {
+		reason := s.skipReason(schedule, sn, now)
+
+		for _, c := range schedule.Commands {
+			cmd := Command{
+				ID:       randomCommandID(),
+				CMD:      c.CMD,
+				Payload:  []byte(c.Payload),
+				Priority: c.Priority,
+			}
+
+			if schedule.TTLSeconds > 0 {
+				cmd.ExpireAt = now.Add(time.Duration(schedule.TTLSeconds) * time.Second)
+			}
+
+			// refused command doesn't skip following commands
+			skip := reason
+			if skip == "" {
+				if err := s.server.putCommandQueue(sn, cmd); err != nil {
+					skip = err.Error()
+				}
+			}
+
+			if skip != "" {
+				log.Printf("schedule %s skipped %s on %s: %s\n", schedule.ID, cmd.CMD, sn, skip)
+
+				s.server.saveCommandRecord(CommandRecord{
+					ID:          cmd.ID,
+					SN:          sn,
+					CMD:         cmd.CMD,
+					Payload:     c.Payload,
+					Priority:    cmd.Priority,
+					Schedule:    schedule.ID,
+					Status:      CommandSkipped,
+					Error:       skip,
+					QueuedAt:    now,
+					CompletedAt: now,
+				})
+				continue
+			}
+
+			s.server.recordCommand(cmd.ID, func(record *CommandRecord) {
+				record.Schedule = schedule.ID
+			})
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2019, 5, 20, 8, 30, 10, 0, time.UTC) // monday
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 2 * * *", time.Date(2019, 5, 21, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, 5, 20, 9, 0, 0, 0, time.UTC)},
+		{"*/15 8-17 * * 1-5", time.Date(2019, 5, 20, 8, 45, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2019, 5, 26, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 0", time.Date(2019, 5, 26, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		spec, err := parseCron(c.spec)
+		if err != nil {
+			t.Error(c.spec, err)
+			t.FailNow()
+		}
+
+		if next := spec.next(from); !next.Equal(c.next) {
+			t.Errorf("%s: expected %v but returned %v", c.spec, c.next, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(spec); err != ErrInvalidCronSpec {
+			t.Errorf("%q: expected invalid spec but returned %v", spec, err)
+		}
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("1")
+	s.RegisterDevice("2")
+
+	s.Registry().SetGroups("1", []string{"lobby"})
+	s.Registry().SetGroups("2", []string{"lobby"})
+
+	now := time.Now()
+	s.Registry().Touch("1", "", now)
+
+	scheduler := NewScheduler(s, nil)
+	schedule, err := scheduler.Add(Schedule{
+		Spec:     "* * * * *",
+		Group:    "lobby",
+		Commands: []ScheduleCommand{{CMD: "REBOOT"}},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// first check only plan next run
+	scheduler.check(now)
+	scheduler.check(now.Add(time.Minute * 2))
+
+	cmds, _ := s.getCommandQueue("1")
+	if len(cmds) != 1 || cmds[0].CMD != "REBOOT" {
+		t.Errorf("expected command queued on online device but returned %v", cmds)
+		t.FailNow()
+	}
+
+	if record, _ := s.history.Get(cmds[0].ID); record.Schedule != schedule.ID {
+		t.Errorf("expected command recorded with schedule but returned %+v", record)
+		t.FailNow()
+	}
+
+	// offline device skipped
+	records := s.history.List("2", 0)
+	if len(records) != 1 || records[0].Status != CommandSkipped {
+		t.Errorf("expected skipped run of offline device but returned %+v", records)
+	}
+}
+
+func TestSchedulerSingleReplica(t *testing.T) {
+	state := &SharedState{
+		Queue:  queue.NewQueue(),
+		Store:  queue.NewStore(),
+		PubSub: queue.NewPubSub(),
+		Locker: queue.NewLocker(),
+	}
+
+	store := NewSharedScheduleStore(state)
+	a := NewServer(&ServerOption{SharedState: state, ScheduleStore: store})
+	b := NewServer(&ServerOption{SharedState: state, ScheduleStore: store})
+
+	a.RegisterDevice("1")
+
+	now := time.Now()
+	a.Registry().Touch("1", "", now)
+
+	// added on one replica, seen by the other
+	if _, err := b.Scheduler().Add(Schedule{Spec: "* * * * *", SN: []string{"1"}, Commands: []ScheduleCommand{{CMD: "REBOOT"}}}); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	for _, s := range []*Server{a, b} {
+		s.Scheduler().check(now)
+	}
+
+	for _, s := range []*Server{a, b} {
+		s.Scheduler().check(now.Add(time.Minute * 2))
+	}
+
+	cmds, _ := a.getCommandQueue("1")
+	if len(cmds) != 1 {
+		t.Errorf("expected command queued once but returned %v", cmds)
+	}
+}
+
+func TestAdminSchedules(t *testing.T) {
+	s := NewServer(&ServerOption{ScheduleStore: NewScheduleStore()})
+	h := s.AdminHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/schedules", strings.NewReader(`{"spec": "0 2 * * *", "group": "lobby", "commands": [{"cmd": "REBOOT"}]}`)))
+
+	var schedule Schedule
+	json.NewDecoder(w.Body).Decode(&schedule)
+
+	if w.Code != http.StatusCreated || schedule.ID == "" || schedule.Offline != OfflineSkip {
+		t.Errorf("expected schedule created but returned %d %+v", w.Code, schedule)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/schedules/"+schedule.ID, strings.NewReader(`{"spec": "invalid", "commands": [{"cmd": "REBOOT"}]}`)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but returned %d", w.Code)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/schedules/"+schedule.ID, strings.NewReader(`{"spec": "0 2 * * *", "commands": [{"cmd": "CHECK", "payload": "x\nC:99:CLEAR DATA"}]}`)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 on injected command but returned %d", w.Code)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("DELETE", "/schedules/"+schedule.ID, nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 but returned %d", w.Code)
+		t.FailNow()
+	}
+
+	if schedules, _ := s.Scheduler().List(); len(schedules) != 0 {
+		t.Errorf("expected schedule removed but returned %+v", schedules)
+		t.FailNow()
+	}
+
+	// scheduler not enabled
+	w = httptest.NewRecorder()
+	NewServer(&ServerOption{}).AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/schedules", nil))
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected status 501 but returned %d", w.Code)
+	}
+}
@@ -3,15 +3,13 @@ package push
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
-	"github.com/galihrivanto/runner"
 	"github.com/gorilla/mux"
 )
 
@@ -73,23 +71,132 @@ type ServerOption struct {
 	Name    string
 	Address string
 
+	// path prefix of protocol endpoints, e.g. /zk
+	// serve /zk/iclock/cdata. empty means root
+	PathPrefix string
+
 	// tls setting
 	CertFile string
 	KeyFile  string
+
+	// retry policy applied to commands
+	// which doesn't define one
+	DefaultRetry *RetryPolicy
+
+	// expire time applied to commands which
+	// doesn't define one. zero means never expired
+	CommandTTL time.Duration
+
+	// maximum commands and bytes sent on single
+	// device poll, remaining commands stay queued
+	// until next poll. zero means unlimited
+	MaxCommandsPerPoll int
+	MaxBytesPerPoll    int
+
+	// device considered offline when silent longer than
+	// OfflineMultiplier times its Delay, or OfflineTimeout
+	// when Delay is unknown
+	OfflineMultiplier int
+	OfflineTimeout    time.Duration
+
+	// comm key of allowed devices. when defined, request
+	// from unknown device or without valid comm key rejected
+	Secrets SecretStore
+
+	// requesting device banned for AuthBanDuration after
+	// MaxAuthFailures failed authentication, its address
+	// after 5 times as many failures of any serial number
+	MaxAuthFailures int
+	AuthBanDuration time.Duration
+
+	// cipher of encrypted payload, implementing firmware scheme.
+	// when defined, encryption negotiated for every device which
+	// has comm key on Secrets. nil means payload never encrypted
+	Cipher Cipher
+
+	// storage of uploaded attendance photos
+	PhotoStore PhotoStore
+
+	// storage of last upload stamps, used on initial exchange
+	// when hook doesn't define stamps. default in memory
+	StampStore StampStore
+
+	// storage of command records. default in memory
+	CommandHistory CommandHistory
+
+	// storage of scheduled commands. when defined, scheduler
+	// run along maintenance and schedules managed through
+	// management api. use SharedScheduleStore with replicas
+	ScheduleStore ScheduleStore
+
+	// state shared by replicas behind load balancer.
+	// nil means state kept in memory of single server
+	SharedState *SharedState
+
+	// path prefix of management api, e.g. /admin. empty
+	// means management api not served by Handler. served
+	// only when hook provide admin middlewares, e.g.
+	// authentication of operator
+	AdminPrefix string
+
+	// location of device clock when exchange doesn't
+	// define one. nil means server local time
+	DeviceLocation *time.Location
+
+	// address of server reachable by devices, e.g.
+	// http://10.0.0.1:8080, used on url of files
+	// downloaded by device. required by PutFile
+	PublicURL string
 }
 
+// interval of dropping expired and lost commands
+var commandSweepInterval = time.Second * 10
+
+// interval of checking silent devices
+var presenceCheckInterval = time.Second * 10
+
+// maximum time waiting in-flight requests on shutdown
+var shutdownTimeout = time.Second * 10
+
+// default waiting time of command result when context has no deadline,
+// command sent without retry isn't tracked once delivered
+var defaultResultTimeout = time.Minute * 10
+
 // Server is http server which
 // implement push service protocol
 type Server struct {
 	option *ServerOption
 
+	// identity of replica, e.g. owner of shared lock
+	id string
+
 	// hook define callback to extend
 	// push service function
 	hook ServerHook
 
-	// flag to ensure server started
-	// before issuing command to device
-	started bool
+	// closed when server started
+	// before issuing command to device,
+	// renewed when server stopped
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// closed when server stopping, so event streams
+	// of current run finish. event bus kept open, so
+	// subscribers outlive restart
+	stop chan struct{}
+
+	// running http server and its bound address
+	lock sync.Mutex
+	srv  *http.Server
+	addr net.Addr
+
+	// routed and decorated protocol handler
+	handler     http.Handler
+	handlerOnce sync.Once
+
+	// routed and decorated management handler
+	admin     http.Handler
+	adminOnce sync.Once
 
 	// deviceCommands map, only allowed devices should be listed here.
 	// those device which "sync'ed" on initial exchange
@@ -97,6 +204,30 @@ type Server struct {
 	// return error device not found if not in list
 	deviceCommands sync.Map
 
+	// registered devices and their presence
+	registry *DeviceRegistry
+
+	// track failed device authentication
+	authLimiter *authLimiter
+
+	// sessions of devices which use push protocol 3.x
+	sessions sessionStore
+
+	// last upload stamps of devices
+	stamps StampStore
+
+	// records of sent commands
+	history CommandHistory
+
+	// fan out device events to subscribers
+	events *EventBus
+
+	// files waiting to be downloaded by devices
+	files fileTokens
+
+	// queue commands of due schedules, nil when disabled
+	scheduler *Scheduler
+
 	// lisf of in-fligh commands which "sent" to device
 	// upon receiveing response, corresponding command callback
 	// will be triggered.
@@ -104,72 +235,313 @@ type Server struct {
 	commandCallbacks sync.Map
 }
 
-func (s *Server) getCommandQueue(sn string) ([]Command, error) {
-	// get command queued to target device
+func (s *Server) getDeviceQueue(sn string) (commandQueue, error) {
+	// queue of device known to any replica
+	if state := s.option.SharedState; state != nil {
+		if _, ok := s.registry.Get(sn); !ok {
+			return nil, ErrDeviceNotRegistered
+		}
+
+		return &sharedQueue{state: state, sn: sn}, nil
+	}
+
+	// get command queue of target device
 	v, ok := s.deviceCommands.Load(sn)
 	if !ok || v == nil {
 		return nil, ErrDeviceNotRegistered
 	}
 
-	cmds, ok := v.([]Command)
+	queue, ok := v.(*deviceQueue)
 	if !ok {
 		return nil, ErrDeviceNotRegistered
 	}
 
-	return cmds, nil
+	return queue, nil
 }
 
-func (s *Server) flushCommandQueue(sn string) error {
-	// get command queued to target device
-	v, ok := s.deviceCommands.Load(sn)
-	if !ok || v == nil {
-		return ErrDeviceNotRegistered
+func (s *Server) getCommandQueue(sn string) ([]Command, error) {
+	queue, err := s.getDeviceQueue(sn)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue.commands(), nil
+}
+
+// pollCommandQueue take commands which should be sent to device
+// and fail those which expired or never acknowledged
+func (s *Server) pollCommandQueue(sn string) ([]Command, error) {
+	queue, err := s.getDeviceQueue(sn)
+	if err != nil {
+		return nil, err
 	}
 
-	s.deviceCommands.Store(sn, make([]Command, 0))
+	cmds, failed := queue.poll(time.Now(), batchLimit{
+		commands: s.option.MaxCommandsPerPoll,
+		bytes:    s.option.MaxBytesPerPoll,
+	})
+	for _, f := range failed {
+		s.failCommand(f.cmd, f.err)
+	}
 
-	return nil
+	now := time.Now()
+	for _, cmd := range cmds {
+		s.recordCommand(cmd.ID, func(record *CommandRecord) {
+			record.Status = CommandSent
+			record.Attempts++
+			record.SentAt = now
+		})
+	}
+
+	return cmds, nil
 }
 
 func (s *Server) putCommandQueue(sn string, cmds ...Command) error {
-	queue, err := s.getCommandQueue(sn)
+	queue, err := s.getDeviceQueue(sn)
 	if err != nil {
 		return err
 	}
 
+	// refuse command which device can't perform
+	if status, ok := s.registry.Get(sn); ok {
+		for _, cmd := range cmds {
+			if err := status.Capabilities.Allow(cmd); err != nil {
+				return err
+			}
+		}
+	}
+
+	// apply default delivery policy
+	for i := range cmds {
+		if cmds[i].Retry == nil {
+			cmds[i].Retry = s.option.DefaultRetry
+		}
+
+		if cmds[i].ExpireAt.IsZero() && s.option.CommandTTL > 0 {
+			cmds[i].ExpireAt = time.Now().Add(s.option.CommandTTL)
+		}
+	}
+
 	// update queue
-	queue = append(queue, cmds...)
-	s.deviceCommands.Store(sn, queue)
+	queue.put(cmds...)
+
+	now := time.Now()
+	for _, cmd := range cmds {
+		s.saveCommandRecord(CommandRecord{
+			ID:       cmd.ID,
+			SN:       sn,
+			CMD:      cmd.CMD,
+			Payload:  string(cmd.Payload),
+			Priority: cmd.Priority,
+			Status:   CommandQueued,
+			QueuedAt: now,
+		})
+	}
 
 	return nil
 }
 
+// ackCommand mark command as delivered
+func (s *Server) ackCommand(sn string, id string) {
+	if queue, err := s.getDeviceQueue(sn); err == nil {
+		queue.ack(id)
+	}
+}
+
+// failCommand remove command callback and notify failure
+func (s *Server) failCommand(cmd Command, err error) {
+	log.Printf("command %s (%s) failed: %v\n", cmd.ID, cmd.CMD, err)
+
+	record := s.recordCommand(cmd.ID, func(record *CommandRecord) {
+		record.CMD = cmd.CMD
+		record.Status = CommandFailed
+		record.Error = err.Error()
+		record.CompletedAt = time.Now()
+	})
+
+	s.publish(EventCommandResult, record.SN, record)
+
+	// callback kept on issuing replica
+	if callback, ok := s.takeCommandCallback(cmd.ID); ok {
+		cmd.OnFailure = callback.OnFailure
+	} else if cmd.OnFailure == nil && s.option.SharedState != nil {
+		s.routeResult(sharedResult{ID: cmd.ID, Error: err.Error()})
+		return
+	}
+
+	if cmd.OnFailure != nil {
+		cmd.OnFailure(cmd, err)
+	}
+}
+
+// sweepCommandQueues periodically drop expired and
+// lost commands, including those of silent devices
+func (s *Server) sweepCommandQueues(ctx context.Context) {
+	ticker := time.NewTicker(commandSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, queue := range s.deviceQueues() {
+				for _, f := range queue.sweep(now) {
+					s.failCommand(f.cmd, f.err)
+				}
+			}
+		}
+	}
+}
+
+// deviceQueues return command queues of registered devices
+func (s *Server) deviceQueues() []commandQueue {
+	var queues []commandQueue
+
+	if state := s.option.SharedState; state != nil {
+		for _, status := range s.registry.List() {
+			queues = append(queues, &sharedQueue{state: state, sn: status.SN})
+		}
+
+		return queues
+	}
+
+	s.deviceCommands.Range(func(k, v interface{}) bool {
+		if queue, ok := v.(*deviceQueue); ok {
+			queues = append(queues, queue)
+		}
+
+		return true
+	})
+
+	return queues
+}
+
 func (s *Server) registerCommandCallback(id string, cmd Command) {
 	s.commandCallbacks.Store(id, cmd)
 }
 
-func (s *Server) getCommandCallback(id string) (Command, error) {
-	v, ok := s.commandCallbacks.Load(id)
+// takeCommandCallback remove and return callback of command, taken
+// once so result and failure never both reach issuer
+func (s *Server) takeCommandCallback(id string) (Command, bool) {
+	v, ok := s.commandCallbacks.LoadAndDelete(id)
 	if !ok {
-		return Command{}, errors.New("Callback not found")
+		return Command{}, false
 	}
 
 	cmd, ok := v.(Command)
-	if !ok {
-		return Command{}, errors.New("Callback not found")
-	}
 
-	return cmd, nil
+	return cmd, ok
 }
 
-func (s *Server) removeCommandCallback(sn string) {
-	s.commandCallbacks.Delete(sn)
+func (s *Server) removeCommandCallback(id string) {
+	s.commandCallbacks.Delete(id)
 }
 
 // RegisterDevice add device to registered device
 // without waiting initial exchange
 func (s *Server) RegisterDevice(sn string) {
-	s.deviceCommands.Store(sn, make([]Command, 0))
+	if s.option.SharedState == nil {
+		s.deviceCommands.LoadOrStore(sn, newDeviceQueue())
+	}
+
+	s.registry.Register(sn)
+}
+
+// Scheduler return scheduler of server,
+// nil when ScheduleStore not defined
+func (s *Server) Scheduler() *Scheduler {
+	return s.scheduler
+}
+
+// Registry return registered devices and their presence
+func (s *Server) Registry() *DeviceRegistry {
+	return s.registry
+}
+
+// trackPresence mark requesting device as seen. done after
+// handler so device registered on exchange is counted
+func (s *Server) trackPresence(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if sn := r.URL.Query().Get("SN"); sn != "" {
+			status, online := s.registry.Touch(sn, remoteIP(r.RemoteAddr), time.Now())
+			if online {
+				s.notifyPresence(status)
+			}
+		}
+	})
+}
+
+// requireSession reject device which use push protocol 3.x but
+// not yet registered or without session token, so it will repeat
+// registry handshake. legacy devices pass through
+func (s *Server) requireSession(accept credential, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sn := r.URL.Query().Get("SN")
+
+		status, ok := s.registry.Get(sn)
+		if !ok || protocolVersion(status.PushVersion) < registryProtocolVersion {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess, ok := s.sessions.get(sn)
+		if !ok || !sess.valid(sessionToken(r), accept) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notifyPresence call presence hook, if defined
+func (s *Server) notifyPresence(status DeviceStatus) {
+	if status.Online {
+		s.publish(EventDeviceOnline, status.SN, status)
+	} else {
+		s.publish(EventDeviceOffline, status.SN, status)
+	}
+
+	if s.hook == nil {
+		return
+	}
+
+	hook, ok := s.hook.(PresenceHook)
+	if !ok {
+		return
+	}
+
+	if status.Online {
+		hook.OnDeviceOnline(status)
+	} else {
+		hook.OnDeviceOffline(status)
+	}
+}
+
+// monitorPresence periodically mark silent devices offline
+func (s *Server) monitorPresence(ctx context.Context) {
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// offline reported once across replicas
+			if !s.lead("presence", presenceCheckInterval) {
+				continue
+			}
+
+			for _, status := range s.registry.expire(now) {
+				log.Printf("device %s is offline\n", status.SN)
+				s.notifyPresence(status)
+			}
+		}
+	}
 }
 
 // DoBackground send single or multiple command to target device
@@ -180,41 +552,126 @@ func (s *Server) DoBackground(target string, cmds ...Command) error {
 	for _, cmd := range cmds {
 		cmd.ID = randomCommandID()
 
+		// put in callback list
+		s.registerCommandCallback(cmd.ID, cmd)
+
 		// put in command queue
 		if err := s.putCommandQueue(target, cmd); err != nil {
+			s.removeCommandCallback(cmd.ID)
 			return err
 		}
-
-		// put in callback list
-		s.registerCommandCallback(cmd.ID, cmd)
 	}
 
 	return nil
 }
 
-// Do execute single command and wait until received response
+// Do execute single command and wait until received response.
+// returns error when command expired or not acknowledged
+// according to its retry policy
 func (s *Server) Do(target string, cmd Command) (CommandResponse, error) {
+	return s.DoContext(context.Background(), target, cmd)
+}
+
+// DoContext execute single command and wait until received response
+// or context cancelled. command which already queued is not recalled
+// on cancel, only its result is discarded. context without deadline
+// wait at most 10 minutes
+func (s *Server) DoContext(ctx context.Context, target string, cmd Command) (CommandResponse, error) {
 	cmd.ID = randomCommandID()
 
-	// put in command queue
-	if err := s.putCommandQueue(target, cmd); err != nil {
+	return s.do(ctx, target, cmd)
+}
+
+// do execute command of id assigned by server, e.g. to
+// correlate progress of file transfer before it is queued
+func (s *Server) do(ctx context.Context, target string, cmd Command) (CommandResponse, error) {
+	resps, err := s.doAll(ctx, target, cmd)
+	if err != nil {
 		return CommandResponse{}, err
 	}
 
-	// replace original callback
-	waitc := make(chan CommandResponse, 0)
-	defer close(waitc)
+	return resps[0], nil
+}
 
-	go func() {
-		cmd.Callback = func(resp CommandResponse) {
-			waitc <- resp
+// doAll execute commands queued at once, so device receive them on
+// single poll as far as batch limit allow, then wait until all of
+// them responded. id assigned when empty. first failure returned,
+// responses of remaining commands discarded
+func (s *Server) doAll(ctx context.Context, target string, cmds ...Command) ([]CommandResponse, error) {
+	type result struct {
+		index int
+		resp  CommandResponse
+		err   error
+	}
+
+	// result never arrive when device doesn't respond
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultResultTimeout)
+		defer cancel()
+	}
+
+	// wrap original callbacks
+	waitc := make(chan result, len(cmds))
+
+	for i := range cmds {
+		if cmds[i].ID == "" {
+			cmds[i].ID = randomCommandID()
 		}
 
-		// put in callback list
+		index, callback, onFailure := i, cmds[i].Callback, cmds[i].OnFailure
+
+		cmds[i].Callback = func(resp CommandResponse) {
+			if callback != nil {
+				callback(resp)
+			}
+
+			waitc <- result{index: index, resp: resp}
+		}
+
+		cmds[i].OnFailure = func(c Command, err error) {
+			if onFailure != nil {
+				onFailure(c, err)
+			}
+
+			waitc <- result{index: index, err: err}
+		}
+	}
+
+	// put in callback list
+	for _, cmd := range cmds {
 		s.registerCommandCallback(cmd.ID, cmd)
-	}()
+	}
+
+	removeCallbacks := func() {
+		for _, cmd := range cmds {
+			s.removeCommandCallback(cmd.ID)
+		}
+	}
+
+	// put in command queue
+	if err := s.putCommandQueue(target, cmds...); err != nil {
+		removeCallbacks()
+		return nil, err
+	}
+
+	resps := make([]CommandResponse, len(cmds))
+	for range cmds {
+		select {
+		case r := <-waitc:
+			if r.err != nil {
+				removeCallbacks()
+				return nil, r.err
+			}
 
-	return <-waitc, nil
+			resps[r.index] = r.resp
+		case <-ctx.Done():
+			removeCallbacks()
+			return nil, ctx.Err()
+		}
+	}
+
+	return resps, nil
 }
 
 func (s *Server) registerAPI(router *mux.Router) {
@@ -226,92 +683,254 @@ func (s *Server) registerAPI(router *mux.Router) {
 		}
 	}
 
-	router.Handle("/iclock/cdata", DecorateHandler(http.HandlerFunc(s.handleExchange), mws...)).
+	// device endpoint only accessible by device which send comm key
+	device := func(h http.HandlerFunc) http.Handler {
+		return DecorateHandler(s.authenticate(commKeyOnly, s.trackPresence(h)), mws...)
+	}
+
+	// endpoint which require registry on push protocol 3.x,
+	// session token accepted in place of comm key
+	session := func(h http.HandlerFunc) http.Handler {
+		return DecorateHandler(s.authenticate(sessionID, s.requireSession(sessionID, s.trackPresence(h))), mws...)
+	}
+
+	router.Handle("/iclock/cdata", device(s.handleExchange)).
 		Methods("GET")
 
-	router.Handle("/iclock/getrequest", DecorateHandler(http.HandlerFunc(s.handleInfo), mws...)).
+	router.Handle("/iclock/cdata", session(s.encrypted(s.handleUpload))).
+		Methods("POST")
+
+	router.Handle("/iclock/getrequest", session(s.encrypted(s.handleInfo))).
 		Methods("GET").
 		Queries("INFO", "{.+}")
 
-	router.Handle("/iclock/getrequest", DecorateHandler(http.HandlerFunc(s.handleCommand), mws...)).
+	router.Handle("/iclock/getrequest", session(s.encrypted(s.handleCommand))).
+		Methods("GET")
+
+	router.Handle("/iclock/devicecmd", session(s.encrypted(s.handleCommandResponse))).
+		Methods("POST")
+
+	// push protocol 3.x
+	router.Handle("/iclock/registry", device(s.handleRegistry)).
+		Methods("POST")
+
+	// session token not issued yet, registry code accepted
+	pushConfig := s.requireSession(registryCode, s.trackPresence(http.HandlerFunc(s.handlePushConfig)))
+	router.Handle("/iclock/push", DecorateHandler(s.authenticate(registryCode, pushConfig), mws...)).
+		Methods("GET", "POST")
+
+	router.Handle("/iclock/ping", session(s.handlePing)).
 		Methods("GET")
 
-	router.Handle("/iclock/devicecmd", DecorateHandler(http.HandlerFunc(s.handleCommandResponse), mws...)).
+	router.Handle("/iclock/querydata", session(s.encrypted(s.handleQueryData))).
 		Methods("POST")
 
-	router.Handle("/{path:.*}", DecorateHandler(http.HandlerFunc(s.handleCatchAll), mws...)).Methods("GET", "POST", "PUT", "DELETE")
+	router.Handle("/iclock/rtdata", session(s.handleRealtimeData)).
+		Methods("GET")
+
+	// url of file carry one time token, devices
+	// download it without identifying themselves
+	router.Handle(filePath+"{token}", DecorateHandler(http.HandlerFunc(s.handleFile), mws...)).
+		Methods("GET")
+
+	router.Handle("/{path:.*}", DecorateHandler(s.authenticate(sessionID, http.HandlerFunc(s.handleCatchAll)), mws...)).Methods("GET", "POST", "PUT", "DELETE")
+}
+
+// Handler return http handler which serve push protocol endpoints
+// under configured path prefix, decorated with custom middlewares.
+// useful to mount push protocol inside existing http server,
+// in which case Maintain should be run along
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		router := mux.NewRouter()
+
+		// management api take precedence over protocol catch all.
+		// served along device endpoints only when protected
+		if prefix := strings.TrimSuffix(s.option.AdminPrefix, "/"); prefix != "" {
+			var admin http.Handler = http.StripPrefix(prefix, s.AdminHandler())
+			if len(s.adminMiddlewares()) == 0 {
+				log.Printf("management api not served on %s: %v\n", prefix, ErrAdminUnprotected)
+
+				admin = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					writeAdminError(w, http.StatusForbidden, ErrAdminUnprotected)
+				})
+			}
+
+			router.PathPrefix(prefix + "/").Handler(admin)
+		}
+
+		if prefix := strings.TrimSuffix(s.option.PathPrefix, "/"); prefix != "" {
+			s.registerAPI(router.PathPrefix(prefix).Subrouter())
+		} else {
+			s.registerAPI(router)
+		}
+
+		s.handler = router
+	})
+
+	return s.handler
+}
+
+// Maintain run background maintenance, i.e. drop expired commands,
+// track device presence and run schedules, until context cancelled.
+// this method is blocking and already run by Start
+func (s *Server) Maintain(ctx context.Context) {
+	go s.sweepCommandQueues(ctx)
+
+	if s.scheduler != nil {
+		go s.scheduler.Run(ctx)
+	}
+
+	if s.option.SharedState != nil {
+		go s.receiveResults(ctx)
+	}
+
+	s.monitorPresence(ctx)
 }
 
 // Start run http server which
 // server api endpoints that implement
 // push protocol
-// this method is blocking
-func (s *Server) Start(ctx context.Context) {
+// listener is bound before server signalled ready, and bind error
+// returned immediately. this method is blocking until context
+// cancelled, then server gracefully shutdown
+func (s *Server) Start(ctx context.Context) error {
 	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: s.Handler(),
+	}
+
+	s.lock.Lock()
+	s.srv = srv
+	s.addr = ln.Addr()
+	s.stop = make(chan struct{})
+	s.lock.Unlock()
+
+	go s.Maintain(runCtx)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
 
-	router := mux.NewRouter()
-	s.registerAPI(router)
+	log.Printf("push service is started on %s\n", ln.Addr())
 
-	srv := http.Server{
-		Addr:    s.option.Address,
-		Handler: router,
+	// listener bound, ready to accept connection
+	s.lock.Lock()
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+	s.lock.Unlock()
+
+	select {
+	case err := <-errc:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+
+		return err
+	case <-runCtx.Done():
 	}
 
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return s.Shutdown(shutdownCtx)
+}
+
+// listen bind server address, wrapped by TLS when enabled
+func (s *Server) listen() (net.Listener, error) {
 	enableTLS := s.option.CertFile != "" && s.option.KeyFile != ""
 
-	// enable TLS
+	var config *tls.Config
 	if enableTLS {
-		srv.TLSConfig = &tls.Config{
-			ServerName: s.option.Name,
-			MinVersion: tls.VersionTLS12,
+		cert, err := tls.LoadX509KeyPair(s.option.CertFile, s.option.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config = &tls.Config{
+			ServerName:   s.option.Name,
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
 		}
 	}
 
-	runner.
-		Run(runCtx, func(ctx context.Context) error {
-			s.started = true
+	ln, err := net.Listen("tcp", s.option.Address)
+	if err != nil {
+		return nil, err
+	}
 
-			if enableTLS {
-				log.Println("Starting HTTPS service")
-				log.Printf("HTTPS service is started on %s\n", s.option.Address)
+	if enableTLS {
+		log.Println("Starting HTTPS service")
+		return tls.NewListener(ln, config), nil
+	}
 
-				return srv.ListenAndServeTLS(s.option.CertFile, s.option.KeyFile)
-			}
+	log.Println("Starting HTTP service")
 
-			log.Println("Starting HTTP service")
-			log.Printf("HTTP service is started on %s\n", s.option.Address)
+	return ln, nil
+}
 
-			return srv.ListenAndServe()
-		}).
-		Handle(func(sig os.Signal) {
-			if sig == syscall.SIGHUP {
-				return
-			}
+// Shutdown gracefully stop server, wait until
+// in-flight requests completed or context cancelled
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	srv := s.srv
 
-			log.Println("Shutting down...")
-			cancel()
-		})
+	// finish event streams, which otherwise hold connections
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+
+	// not ready until started again
+	if srv != nil {
+		s.srv = nil
+		s.ready = make(chan struct{})
+		s.readyOnce = sync.Once{}
+	}
+	s.lock.Unlock()
 
+	if srv == nil {
+		return nil
+	}
+
+	return srv.Shutdown(ctx)
 }
 
-// Ready wait until server ready
+// Ready return channel which closed when
+// server is accepting connection
 func (s *Server) Ready() <-chan struct{} {
-	// check if server started
-	waitc := make(chan struct{}, 1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	go func() {
-		defer close(waitc)
+	return s.ready
+}
 
-		for {
-			if s.started {
-				return
-			}
+// stopping return channel which closed when current run of
+// server stopping, nil channel when server not started
+func (s *Server) stopping() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-			<-time.After(time.Second)
-		}
-	}()
+	return s.stop
+}
+
+// Addr return bound server address, nil if not started
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	return waitc
+	return s.addr
 }
 
 // NewServer create and start push service
@@ -321,8 +940,37 @@ func NewServer(option *ServerOption, hook ...ServerHook) *Server {
 		h = hook[0]
 	}
 
-	return &Server{
-		option: option,
-		hook:   h,
+	stamps := option.StampStore
+	if stamps == nil {
+		stamps = NewStampStore()
+	}
+
+	history := option.CommandHistory
+	if history == nil {
+		history = NewCommandHistory(defaultHistorySize)
 	}
+
+	s := &Server{
+		id:          randomCommandID(),
+		option:      option,
+		hook:        h,
+		registry:    NewDeviceRegistry(option.OfflineMultiplier, option.OfflineTimeout),
+		authLimiter: newAuthLimiter(option.MaxAuthFailures, option.AuthBanDuration),
+		stamps:      stamps,
+		history:     history,
+		events:      NewEventBus(),
+		ready:       make(chan struct{}),
+	}
+
+	if option.ScheduleStore != nil {
+		s.scheduler = NewScheduler(s, option.ScheduleStore)
+	}
+
+	// keep devices and sessions visible to other replicas
+	if state := option.SharedState; state != nil {
+		s.registry.share(state.Store, state.key("devices"))
+		s.sessions.store, s.sessions.name = state.Store, state.key("sessions")
+	}
+
+	return s
 }
@@ -0,0 +1,242 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerPathPrefix(t *testing.T) {
+	s := NewServer(&ServerOption{PathPrefix: "/zk/"})
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/zk/iclock/ping?SN=123456789")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 but returned %d", resp.StatusCode)
+		t.FailNow()
+	}
+
+	// endpoint outside prefix not served
+	resp, err = http.Get(srv.URL + "/iclock/ping?SN=123456789")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 but returned %d", resp.StatusCode)
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	s := NewServer(&ServerOption{Address: "127.0.0.1:0"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Start(ctx)
+	}()
+
+	select {
+	case <-s.Ready():
+	case err := <-errc:
+		t.Error(err)
+		t.FailNow()
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/iclock/ping?SN=123456789")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	// address already in use
+	other := NewServer(&ServerOption{Address: s.Addr().String()})
+	if err := other.Start(context.Background()); err == nil {
+		t.Error("expected bind error")
+		t.FailNow()
+	}
+
+	// subscriber outlive restart
+	sub := s.Events().Subscribe(EventFilter{}, 1)
+	defer sub.Close()
+
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("server not stopped")
+		t.FailNow()
+	}
+
+	select {
+	case <-s.Ready():
+		t.Error("expected not ready after stopped")
+		t.FailNow()
+	default:
+	}
+
+	// started again after stopped
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		errc <- s.Start(ctx)
+	}()
+
+	select {
+	case <-s.Ready():
+	case err := <-errc:
+		t.Error(err)
+		t.FailNow()
+	}
+
+	s.publish(EventDeviceOnline, "123456789", nil)
+
+	select {
+	case e, ok := <-sub.C:
+		if !ok || e.Type != EventDeviceOnline {
+			t.Errorf("expected event after restart but returned %+v", e)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Error("expected event after restart")
+		t.FailNow()
+	}
+
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("server not stopped")
+	}
+}
+
+func TestDoContextReusedCommand(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// same command issued twice, each wait its own result
+	cmd := Command{ID: "1", CMD: "CHECK"}
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := s.DoContext(ctx, "123456789", cmd)
+			done <- err
+		}()
+	}
+
+	var ids []string
+	for i := 0; i < 50 && len(ids) < 2; i++ {
+		if cmds, _ := s.getCommandQueue("123456789"); len(cmds) == 2 {
+			ids = []string{cmds[0].ID, cmds[1].ID}
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if len(ids) != 2 || ids[0] == "1" || ids[1] == "1" || ids[0] == ids[1] {
+		t.Errorf("expected distinct ids assigned by server but returned %v", ids)
+		t.FailNow()
+	}
+
+	for _, id := range ids {
+		w := httptest.NewRecorder()
+		s.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN=123456789", strings.NewReader("ID="+id+"&Return=0&CMD=CHECK")))
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+		case <-time.After(time.Second * 5):
+			t.Error("result not delivered to both callers")
+			t.FailNow()
+		}
+	}
+}
+
+func TestDoCallbacks(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	failed := make(chan error, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Do("123456789", Command{CMD: "CHECK", OnFailure: func(_ Command, err error) {
+			failed <- err
+		}})
+		done <- err
+	}()
+
+	var cmd Command
+	for i := 0; i < 50 && cmd.ID == ""; i++ {
+		if cmds, _ := s.getCommandQueue("123456789"); len(cmds) == 1 {
+			cmd = cmds[0]
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if cmd.ID == "" {
+		t.Error("expected command queued")
+		t.FailNow()
+	}
+
+	// expiry sweep race with device response
+	s.failCommand(cmd, ErrCommandExpired)
+	s.completeCommand("123456789", CommandResponse{ID: cmd.ID, CMD: "CHECK"})
+
+	if err := <-done; err != ErrCommandExpired {
+		t.Errorf("expected command expired but returned %v", err)
+		t.FailNow()
+	}
+
+	select {
+	case err := <-failed:
+		if err != ErrCommandExpired {
+			t.Errorf("expected failure callback of command expired but returned %v", err)
+		}
+	default:
+		t.Error("expected caller failure callback called")
+	}
+}
+
+func TestDoDefaultTimeout(t *testing.T) {
+	timeout := defaultResultTimeout
+	defaultResultTimeout = time.Millisecond * 50
+	defer func() { defaultResultTimeout = timeout }()
+
+	s := NewServer(&ServerOption{})
+	s.RegisterDevice("123456789")
+
+	// device never respond
+	if _, err := s.Do("123456789", Command{CMD: "CHECK"}); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded but returned %v", err)
+	}
+}
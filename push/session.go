@@ -0,0 +1,249 @@
+package push
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// push protocol version which use registry
+// handshake instead of initial exchange
+const registryProtocolVersion = 3
+
+// protocolVersion return major version of push protocol version
+func protocolVersion(v string) int {
+	if i := strings.Index(v, "."); i != -1 {
+		v = v[:i]
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// session of device which registered using push protocol 3.x
+type session struct {
+	// code issued on registry
+	RegistryCode string
+
+	// id issued on push config download
+	ID string
+
+	// transmission config resolved on registry
+	Config ExchangeCommand
+}
+
+// credential accepted by endpoint from device on push protocol 3.x
+type credential int
+
+const (
+	// comm key only, e.g. on registry
+	commKeyOnly credential = iota
+
+	// session token issued on push config
+	sessionID
+
+	// registry code, before session token issued
+	registryCode
+)
+
+// valid check token sent by device against accepted credential
+func (s *session) valid(token string, accept credential) bool {
+	switch {
+	case token == "" || accept == commKeyOnly:
+		return false
+	case subtle.ConstantTimeCompare([]byte(token), []byte(s.ID)) == 1:
+		return true
+	}
+
+	return accept == registryCode && subtle.ConstantTimeCompare([]byte(token), []byte(s.RegistryCode)) == 1
+}
+
+// sessionToken return token cookie sent by device
+func sessionToken(r *http.Request) string {
+	c, err := r.Cookie("token")
+	if err != nil {
+		return ""
+	}
+
+	return c.Value
+}
+
+// sessionStore keep registered sessions
+type sessionStore struct {
+	sessions sync.Map
+
+	// shared storage of sessions, nil means kept in memory
+	store queue.Store
+	name  string
+}
+
+func (s *sessionStore) get(sn string) (*session, bool) {
+	if s.store != nil {
+		v, err := s.store.Get(s.name, sn)
+		if err != nil {
+			return nil, false
+		}
+
+		var sess session
+		if err := json.Unmarshal([]byte(v), &sess); err != nil {
+			log.Printf("failed to decode session of %s: %v\n", sn, err)
+			return nil, false
+		}
+
+		return &sess, true
+	}
+
+	v, ok := s.sessions.Load(sn)
+	if !ok {
+		return nil, false
+	}
+
+	sess, ok := v.(*session)
+
+	return sess, ok
+}
+
+// open start new session, replacing previous one
+func (s *sessionStore) open(sn string, config ExchangeCommand) *session {
+	sess := &session{
+		RegistryCode: strings.Replace(randomCommandID(), "-", "", -1),
+		ID:           strings.Replace(randomCommandID(), "-", "", -1),
+		Config:       config,
+	}
+
+	if s.store == nil {
+		s.sessions.Store(sn, sess)
+		return sess
+	}
+
+	b, err := json.Marshal(sess)
+	if err == nil {
+		err = s.store.Set(s.name, sn, string(b))
+	}
+
+	if err != nil {
+		log.Printf("failed to save session of %s: %v\n", sn, err)
+	}
+
+	return sess
+}
+
+// parseOptions parse key value list, e.g. device parameters on registry
+// body which separated by comma or query data which separated by tab
+func parseOptions(b []byte, sep string) map[string]string {
+	options := make(map[string]string)
+
+	for _, line := range strings.Split(string(b), "\n") {
+		for _, field := range strings.Split(line, sep) {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				continue
+			}
+
+			options[kv[0]] = kv[1]
+		}
+	}
+
+	return options
+}
+
+// marshallPushConfig encode transmission config sent
+// upon device request on push protocol 3.x
+func marshallPushConfig(c ExchangeCommand, sessionID string) []byte {
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+
+	serverVer := c.ServerVer
+	if serverVer == "" {
+		serverVer = "3.0.1"
+	}
+
+	buf.WriteString("ServerVersion=" + serverVer)
+	writeStringValue(buf, "PushVersion", lf, serverVer)
+	writeIntValue(buf, "ErrorDelay", lf, c.ErrorDelay)
+	writeIntValue(buf, "RequestDelay", lf, c.Delay)
+	writeStringValue(buf, "TransTimes", lf, c.TransTimes)
+	writeIntValue(buf, "TransInterval", lf, c.TransInterval)
+	writeStringValue(buf, "TransTables", lf, c.TransFlag)
+	writeIntValue(buf, "TimeZone", lf, c.TimeZone)
+	writeIntValue(buf, "Realtime", lf, c.Realtime)
+	writeIntValue(buf, "Encrypt", lf, c.Encrypt, true)
+	writeStringValue(buf, "SessionID", lf, sessionID)
+
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// encodeDeviceTime encode time as device seconds
+// which used by realtime time sync
+func encodeDeviceTime(t time.Time) int {
+	return ((t.Year()%100)*12*31+((int(t.Month())-1)*31)+t.Day()-1)*(24*60*60) +
+		(t.Hour()*60+t.Minute())*60 + t.Second()
+}
+
+// formatTimeZone format offset in seconds as +HHMM
+func formatTimeZone(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// QueryData represent table data uploaded by device on push protocol
+// 3.x as result of data query command
+type QueryData struct {
+	// query type, e.g. tabledata
+	Type string `json:"type"`
+
+	// id of query command
+	CommandID string `json:"command_id"`
+
+	// queried table
+	Table string `json:"table"`
+
+	// total records and packet position
+	Count       int `json:"count"`
+	PacketCount int `json:"packet_count"`
+	PacketIndex int `json:"packet_index"`
+
+	// records of this packet
+	Records []map[string]string `json:"records"`
+}
+
+// QueryDataHook define callback upon receiving query data
+type QueryDataHook interface {
+	OnQueryData(sn string, data QueryData)
+}
+
+// parseQueryRecords parse tab separated records, one per line.
+// line prefixed with table name is stripped
+func parseQueryRecords(table string, b []byte) []map[string]string {
+	var records []map[string]string
+
+	for _, line := range bytes.Split(b, lf) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		line = bytes.TrimPrefix(line, []byte(table+" "))
+		records = append(records, parseOptions(line, "\t"))
+	}
+
+	return records
+}
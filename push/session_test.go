@@ -0,0 +1,106 @@
+package push
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type registryHook struct{}
+
+func (h registryHook) OnInitialExchange(d Device) *ExchangeCommand {
+	return &ExchangeCommand{SN: d.SN, Delay: 10, TimeZone: 7}
+}
+
+func TestRegistryHandshake(t *testing.T) {
+	s := NewServer(&ServerOption{}, registryHook{})
+
+	body := "DeviceType=acc,~DeviceName=SpeedFace,FirmVer=ZAM180,PushVersion=3.1.2"
+
+	w := httptest.NewRecorder()
+	s.handleRegistry(w, httptest.NewRequest("POST", "/iclock/registry?SN=123456789", strings.NewReader(body)))
+
+	if !strings.HasPrefix(w.Body.String(), "RegistryCode=") {
+		t.Errorf("expected registry code but returned %s", w.Body.String())
+		t.FailNow()
+	}
+
+	status, ok := s.Registry().Get("123456789")
+	if !ok || protocolVersion(status.PushVersion) != 3 {
+		t.Errorf("expected device registered with push version 3 but returned %v", status)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	s.handlePushConfig(w, httptest.NewRequest("POST", "/iclock/push?SN=123456789", nil))
+
+	config := parseOptions(w.Body.Bytes(), "\n")
+	if config["RequestDelay"] != "10" || config["SessionID"] == "" {
+		t.Errorf("expected push config with session but returned %s", w.Body.String())
+	}
+}
+
+func TestRegistrySessionRequired(t *testing.T) {
+	secrets := NewSecretStore()
+	secrets.Set("123456789", "secret")
+
+	s := NewServer(&ServerOption{Secrets: secrets}, registryHook{})
+	h := s.Handler()
+
+	request := func(method, url, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			r.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w
+	}
+
+	body := "DeviceType=acc,~DeviceName=SpeedFace,PushVersion=3.1.2"
+
+	// registry without comm key can't replace session
+	if w := request("POST", "/iclock/registry?SN=123456789", "", body); w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 but returned %d", w.Code)
+		t.FailNow()
+	}
+
+	w := request("POST", "/iclock/registry?SN=123456789&pushcommkey=secret", "", body)
+	code := strings.TrimPrefix(w.Body.String(), "RegistryCode=")
+	if w.Code != http.StatusOK || code == "" {
+		t.Errorf("expected registry code but returned %d %s", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	// push config requested with registry code
+	w = request("GET", "/iclock/push?SN=123456789", code, "")
+	token := parseOptions(w.Body.Bytes(), "\n")["SessionID"]
+	if w.Code != http.StatusOK || token == "" {
+		t.Errorf("expected push config but returned %d %s", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusForbidden},
+		{"invalid", http.StatusForbidden},
+		{code, http.StatusForbidden},
+		{token, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		if w := request("GET", "/iclock/getrequest?SN=123456789", tc.token, ""); w.Code != tc.status {
+			t.Errorf("expected status %d with token %q but returned %d", tc.status, tc.token, w.Code)
+		}
+	}
+
+	// comm key doesn't replace session token
+	if w := request("GET", "/iclock/getrequest?SN=123456789&pushcommkey=secret", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 but returned %d", w.Code)
+	}
+}
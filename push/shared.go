@@ -0,0 +1,525 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// default key prefix of shared state
+const defaultSharedPrefix = "push"
+
+// delay before subscribe command results again after subscription
+// lost, doubled on each failure up to max delay
+var (
+	resubscribeDelay    = time.Second
+	maxResubscribeDelay = time.Minute
+)
+
+// errors which carried across replicas by its message
+var sharedErrors = []error{
+	ErrCommandExpired,
+	ErrCommandUnacknowledged,
+}
+
+// SharedState define storage shared by server replicas, so device
+// may poll any replica behind load balancer. command queues, device
+// registry and sessions are kept in the store, while command results
+// routed to replica which hold the waiting caller through pub sub.
+// stamp store and command history should be shared as well
+// by providing suitable implementation on server option
+type SharedState struct {
+	// command lanes of each device
+	Queue queue.Queuer
+
+	// pending and in-flight commands, devices and sessions
+	Store queue.Store
+
+	// command results
+	PubSub queue.PubSub
+
+	// elect single replica which run periodic jobs, e.g.
+	// presence expiry. nil means every replica run them
+	Locker queue.Locker
+
+	// prefix of queue, hash and channel names.
+	// default "push"
+	Prefix string
+}
+
+// NewRedisSharedState create shared state backed by redis
+func NewRedisSharedState(option *queue.RedisOption) *SharedState {
+	return &SharedState{
+		Queue:  queue.NewRedisQueue(option),
+		Store:  queue.NewRedisStore(option),
+		PubSub: queue.NewRedisPubSub(option),
+		Locker: queue.NewRedisLocker(option),
+	}
+}
+
+// lead check whether this replica run periodic job of given name,
+// holding lease until next run. single replica elected through
+// shared locker, replica of unshared state always lead
+func (s *Server) lead(job string, interval time.Duration) bool {
+	state := s.option.SharedState
+	if state == nil || state.Locker == nil {
+		return true
+	}
+
+	// lease outlive interval, so leader keep it
+	// while other replicas take over when it stop
+	ok, err := state.Locker.Lock(state.key("leader:%s", job), s.id, interval*3)
+	if err != nil {
+		log.Printf("failed to elect %s runner: %v\n", job, err)
+		return false
+	}
+
+	return ok
+}
+
+// key return prefixed name
+func (s *SharedState) key(format string, args ...interface{}) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = defaultSharedPrefix
+	}
+
+	return prefix + ":" + fmt.Sprintf(format, args...)
+}
+
+// sharedDelivery is stored form of command and its delivery state.
+// callbacks are not stored, they kept by replica which issue command
+type sharedDelivery struct {
+	ID       string          `json:"id"`
+	CMD      string          `json:"cmd"`
+	Payload  []byte          `json:"payload,omitempty"`
+	Priority CommandPriority `json:"priority"`
+	Retry    *RetryPolicy    `json:"retry,omitempty"`
+	ExpireAt time.Time       `json:"expire_at"`
+	QueuedAt time.Time       `json:"queued_at"`
+
+	Attempts int       `json:"attempts"`
+	Polls    int       `json:"polls"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+func newSharedDelivery(cmd Command, now time.Time) *sharedDelivery {
+	return &sharedDelivery{
+		ID:       cmd.ID,
+		CMD:      cmd.CMD,
+		Payload:  cmd.Payload,
+		Priority: cmd.Priority,
+		Retry:    cmd.Retry,
+		ExpireAt: cmd.ExpireAt,
+		QueuedAt: now,
+	}
+}
+
+func (d *sharedDelivery) command() Command {
+	return Command{
+		ID:       d.ID,
+		CMD:      d.CMD,
+		Payload:  d.Payload,
+		Priority: d.Priority,
+		Retry:    d.Retry,
+		ExpireAt: d.ExpireAt,
+	}
+}
+
+// delivery convert into local delivery, to share retry decision
+func (d *sharedDelivery) delivery() *delivery {
+	return &delivery{
+		cmd:      d.command(),
+		attempts: d.Attempts,
+		polls:    d.Polls,
+		sentAt:   d.SentAt,
+	}
+}
+
+// sharedQueue implement command queue of single device on
+// shared state. pending commands kept in store hash and their ids
+// pushed to lane of its priority, so any replica can poll them
+type sharedQueue struct {
+	state *SharedState
+	sn    string
+}
+
+// lane return queue name of priority, lost commands
+// re-delivered through retry lane
+func (q *sharedQueue) lane(priority CommandPriority) string {
+	switch {
+	case priority >= PriorityUrgent:
+		return q.state.key("queue:%s:urgent", q.sn)
+	case priority == PriorityHigh:
+		return q.state.key("queue:%s:high", q.sn)
+	default:
+		return q.state.key("queue:%s:normal", q.sn)
+	}
+}
+
+func (q *sharedQueue) retryLane() string {
+	return q.state.key("queue:%s:retry", q.sn)
+}
+
+func (q *sharedQueue) pendingHash() string {
+	return q.state.key("pending:%s", q.sn)
+}
+
+func (q *sharedQueue) inflightHash() string {
+	return q.state.key("inflight:%s", q.sn)
+}
+
+// carryLane keep commands which didn't fit into last batch,
+// so they sent on next poll without losing their turn. concurrent
+// polls of same device may each leave one behind
+func (q *sharedQueue) carryLane() string {
+	return q.state.key("queue:%s:carry", q.sn)
+}
+
+func (q *sharedQueue) save(name string, d *sharedDelivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return q.state.Store.Set(name, d.ID, string(b))
+}
+
+func (q *sharedQueue) load(name string, id string) (*sharedDelivery, bool) {
+	v, err := q.state.Store.Get(name, id)
+	if err != nil {
+		return nil, false
+	}
+
+	var d sharedDelivery
+	if err := json.Unmarshal([]byte(v), &d); err != nil {
+		log.Printf("failed to decode command %s: %v\n", id, err)
+		return nil, false
+	}
+
+	return &d, true
+}
+
+// all return deliveries of given hash
+func (q *sharedQueue) all(name string) []*sharedDelivery {
+	all, err := q.state.Store.All(name)
+	if err != nil {
+		log.Printf("failed to load commands of %s: %v\n", q.sn, err)
+		return nil
+	}
+
+	list := make([]*sharedDelivery, 0, len(all))
+	for id, v := range all {
+		var d sharedDelivery
+		if err := json.Unmarshal([]byte(v), &d); err != nil {
+			log.Printf("failed to decode command %s: %v\n", id, err)
+			continue
+		}
+
+		list = append(list, &d)
+	}
+
+	return list
+}
+
+// claim remove delivery from hash, return false when
+// already taken by other replica
+func (q *sharedQueue) claim(name string, id string) bool {
+	ok, err := q.state.Store.Delete(name, id)
+	if err != nil {
+		log.Printf("failed to claim command %s: %v\n", id, err)
+	}
+
+	return ok
+}
+
+// put implements commandQueue.put
+func (q *sharedQueue) put(cmds ...Command) {
+	now := time.Now()
+	for _, cmd := range cmds {
+		d := newSharedDelivery(cmd, now)
+		if err := q.save(q.pendingHash(), d); err != nil {
+			log.Printf("failed to queue command %s: %v\n", cmd.ID, err)
+			continue
+		}
+
+		if err := q.state.Queue.Push(q.lane(cmd.Priority), cmd.ID); err != nil {
+			log.Printf("failed to queue command %s: %v\n", cmd.ID, err)
+			q.claim(q.pendingHash(), cmd.ID)
+		}
+	}
+}
+
+// commands implements commandQueue.commands
+func (q *sharedQueue) commands() []Command {
+	pending := q.all(q.pendingHash())
+
+	// higher priority first, then queue order
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Priority != pending[j].Priority {
+			return pending[i].Priority > pending[j].Priority
+		}
+
+		return pending[i].QueuedAt.Before(pending[j].QueuedAt)
+	})
+
+	cmds := make([]Command, 0, len(pending))
+	for _, d := range pending {
+		cmds = append(cmds, d.command())
+	}
+
+	return cmds
+}
+
+// pop take id from lane, false if lane empty
+func (q *sharedQueue) pop(lane string) (string, bool) {
+	if q.state.Queue.Len(lane) == 0 {
+		return "", false
+	}
+
+	id, err := q.state.Queue.Pop(lane)
+
+	return id, err == nil && id != ""
+}
+
+// next take id of next command to be sent, false if none.
+// re-delivered commands take precedence, followed by command
+// which didn't fit into last batch, then by priority
+func (q *sharedQueue) next() (string, bool) {
+	if id, ok := q.pop(q.retryLane()); ok {
+		return id, true
+	}
+
+	if id, ok := q.pop(q.carryLane()); ok {
+		return id, true
+	}
+
+	for _, priority := range []CommandPriority{PriorityUrgent, PriorityHigh, PriorityNormal} {
+		if id, ok := q.pop(q.lane(priority)); ok {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// poll implements commandQueue.poll
+func (q *sharedQueue) poll(now time.Time, limit batchLimit) ([]Command, []failure) {
+	failed := q.checkInflight(now, true)
+
+	var (
+		cmds  = make([]Command, 0)
+		total int
+	)
+
+	for {
+		id, ok := q.next()
+		if !ok {
+			break
+		}
+
+		d, ok := q.load(q.pendingHash(), id)
+		if !ok {
+			// already dropped, e.g. expired
+			continue
+		}
+
+		cmd := d.command()
+		if cmd.expired(now) {
+			if q.claim(q.pendingHash(), id) {
+				failed = append(failed, failure{cmd: cmd, err: ErrCommandExpired})
+			}
+			continue
+		}
+
+		size := cmd.size()
+		if !limit.fit(len(cmds), total, size) {
+			if err := q.state.Queue.Push(q.carryLane(), id); err != nil {
+				log.Printf("failed to carry command %s: %v\n", id, err)
+			}
+			break
+		}
+
+		if !q.claim(q.pendingHash(), id) {
+			continue
+		}
+
+		d.Attempts++
+		d.Polls = 0
+		d.SentAt = now
+
+		if d.Retry != nil {
+			if err := q.save(q.inflightHash(), d); err != nil {
+				log.Printf("failed to track command %s: %v\n", id, err)
+			}
+		}
+
+		cmds = append(cmds, cmd)
+		total += size
+	}
+
+	return cmds, failed
+}
+
+// sweep implements commandQueue.sweep
+func (q *sharedQueue) sweep(now time.Time) []failure {
+	failed := q.checkInflight(now, false)
+
+	// lane entries of dropped commands skipped on poll
+	for _, d := range q.all(q.pendingHash()) {
+		cmd := d.command()
+		if cmd.expired(now) && q.claim(q.pendingHash(), d.ID) {
+			failed = append(failed, failure{cmd: cmd, err: ErrCommandExpired})
+		}
+	}
+
+	return failed
+}
+
+// ack implements commandQueue.ack
+func (q *sharedQueue) ack(id string) bool {
+	return q.claim(q.inflightHash(), id)
+}
+
+// checkInflight move lost commands back to retry lane
+// or give up when max attempts reached
+func (q *sharedQueue) checkInflight(now time.Time, polled bool) []failure {
+	var failed []failure
+
+	for _, d := range q.all(q.inflightHash()) {
+		if polled {
+			d.Polls++
+		}
+
+		if !d.delivery().due(now) {
+			if polled {
+				q.save(q.inflightHash(), d)
+			}
+			continue
+		}
+
+		if !q.claim(q.inflightHash(), d.ID) {
+			continue
+		}
+
+		if d.Attempts >= d.Retry.maxAttempts() {
+			failed = append(failed, failure{cmd: d.command(), err: ErrCommandUnacknowledged})
+			continue
+		}
+
+		if err := q.save(q.pendingHash(), d); err != nil {
+			log.Printf("failed to re-queue command %s: %v\n", d.ID, err)
+			continue
+		}
+
+		q.state.Queue.Push(q.retryLane(), d.ID)
+	}
+
+	return failed
+}
+
+// sharedResult is command result routed between replicas
+type sharedResult struct {
+	ID       string           `json:"id"`
+	Response *CommandResponse `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// err return error of failed command, known errors
+// restored so caller can compare them
+func (r sharedResult) err() error {
+	for _, err := range sharedErrors {
+		if err.Error() == r.Error {
+			return err
+		}
+	}
+
+	return errors.New(r.Error)
+}
+
+// resultChannel return pub sub channel of command results
+func (s *Server) resultChannel() string {
+	return s.option.SharedState.key("results")
+}
+
+// routeResult publish command result to other replicas,
+// which may hold its callback
+func (s *Server) routeResult(result sharedResult) {
+	b, err := json.Marshal(result)
+	if err == nil {
+		err = s.option.SharedState.PubSub.Publish(s.resultChannel(), string(b))
+	}
+
+	if err != nil {
+		log.Printf("failed to route result of command %s: %v\n", result.ID, err)
+	}
+}
+
+// receiveResults trigger callback of command results received by
+// other replicas until context cancelled, subscribe again when
+// subscription lost, e.g. redis connection dropped
+func (s *Server) receiveResults(ctx context.Context) {
+	var delay time.Duration
+
+	for {
+		messages, err := s.option.SharedState.PubSub.Subscribe(ctx, s.resultChannel())
+		if err != nil {
+			log.Printf("failed to subscribe command results: %v\n", err)
+		} else {
+			delay = 0
+			for message := range messages {
+				s.receiveResult(message)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case delay == 0:
+			delay = resubscribeDelay
+		case delay*2 > maxResubscribeDelay:
+			delay = maxResubscribeDelay
+		default:
+			delay *= 2
+		}
+
+		log.Printf("subscription of command results lost, retry in %v\n", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// receiveResult trigger callback of routed command result
+func (s *Server) receiveResult(message string) {
+	var result sharedResult
+	if err := json.Unmarshal([]byte(message), &result); err != nil {
+		log.Printf("failed to decode command result: %v\n", err)
+		return
+	}
+
+	cmd, ok := s.takeCommandCallback(result.ID)
+	if !ok {
+		// held by other replica
+		return
+	}
+
+	if result.Response != nil {
+		if cmd.Callback != nil {
+			cmd.Callback(*result.Response)
+		}
+	} else if cmd.OnFailure != nil {
+		cmd.OnFailure(cmd, result.err())
+	}
+}
@@ -0,0 +1,314 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+// notifyPubSub signal every subscription, first drops subscriptions
+// are closed right away like lost connection
+type notifyPubSub struct {
+	queue.PubSub
+
+	drops      int
+	subscribed chan struct{}
+}
+
+func (p *notifyPubSub) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ch, err := p.PubSub.Subscribe(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { p.subscribed <- struct{}{} }()
+
+	if p.drops > 0 {
+		p.drops--
+
+		lost := make(chan string)
+		close(lost)
+		return lost, nil
+	}
+
+	return ch, nil
+}
+
+// waitSubscribed wait until given number of subscriptions made
+func waitSubscribed(t *testing.T, p *notifyPubSub, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-p.subscribed:
+		case <-time.After(time.Second * 5):
+			t.Error("expected result subscribers ready")
+			t.FailNow()
+		}
+	}
+}
+
+func TestSharedStateReplicas(t *testing.T) {
+	pubsub := &notifyPubSub{PubSub: queue.NewPubSub(), subscribed: make(chan struct{}, 2)}
+	state := &SharedState{
+		Queue:  queue.NewQueue(),
+		Store:  queue.NewStore(),
+		PubSub: pubsub,
+	}
+
+	a := NewServer(&ServerOption{SharedState: state})
+	b := NewServer(&ServerOption{SharedState: state})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go a.Maintain(ctx)
+	go b.Maintain(ctx)
+
+	// device registered on a, known by b
+	a.RegisterDevice("123456789")
+	if _, ok := b.Registry().Get("123456789"); !ok {
+		t.Error("expected device shared between replicas")
+		t.FailNow()
+	}
+
+	waitSubscribed(t, pubsub, 2)
+
+	type result struct {
+		resp CommandResponse
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		resp, err := a.Do("123456789", Command{CMD: "REBOOT"})
+		done <- result{resp, err}
+	}()
+
+	// device poll and respond through b
+	cmd := pollCommand(b, "123456789")
+	if !strings.HasPrefix(cmd, "C:") {
+		t.Errorf("expected command polled from other replica but returned %s", cmd)
+		t.FailNow()
+	}
+
+	id := strings.SplitN(cmd, ":", 3)[1]
+
+	w := httptest.NewRecorder()
+	b.handleCommandResponse(w, httptest.NewRequest("POST", "/iclock/devicecmd?SN=123456789", strings.NewReader("ID="+id+"&Return=0&CMD=REBOOT")))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 but returned %d", w.Code)
+		t.FailNow()
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.resp.ID != id || !r.resp.IsOK() {
+			t.Errorf("unexpected result %+v %v", r.resp, r.err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("result not routed to issuing replica")
+	}
+}
+
+func TestSharedResultResubscribe(t *testing.T) {
+	delay := resubscribeDelay
+	resubscribeDelay = time.Millisecond
+	defer func() { resubscribeDelay = delay }()
+
+	pubsub := &notifyPubSub{PubSub: queue.NewPubSub(), drops: 2, subscribed: make(chan struct{}, 3)}
+	s := NewServer(&ServerOption{SharedState: &SharedState{
+		Queue:  queue.NewQueue(),
+		Store:  queue.NewStore(),
+		PubSub: pubsub,
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.receiveResults(ctx)
+	waitSubscribed(t, pubsub, 3)
+
+	done := make(chan CommandResponse, 1)
+	s.commandCallbacks.Store("1", Command{ID: "1", Callback: func(resp CommandResponse) {
+		done <- resp
+	}})
+
+	s.routeResult(sharedResult{ID: "1", Response: &CommandResponse{ID: "1", CMD: "REBOOT"}})
+
+	select {
+	case resp := <-done:
+		if resp.ID != "1" {
+			t.Errorf("unexpected result %+v", resp)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("expected result received after subscription lost")
+	}
+}
+
+// pairedQueue hold first pops of lane until other poll pop as well,
+// so two polls of same device interleave step by step
+type pairedQueue struct {
+	queue.Queuer
+
+	lane  string
+	mu    sync.Mutex
+	pops  int
+	pairs [2]sync.WaitGroup
+}
+
+func (q *pairedQueue) Pop(name string) (string, error) {
+	if name == q.lane {
+		q.mu.Lock()
+		n := q.pops
+		q.pops++
+		q.mu.Unlock()
+
+		if n < 2*len(q.pairs) {
+			q.pairs[n/2].Done()
+			q.pairs[n/2].Wait()
+		}
+	}
+
+	return q.Queuer.Pop(name)
+}
+
+func TestSharedQueueCarry(t *testing.T) {
+	state := &SharedState{
+		Store:  queue.NewStore(),
+		PubSub: queue.NewPubSub(),
+	}
+
+	q := &sharedQueue{state: state, sn: "123456789"}
+
+	paired := &pairedQueue{Queuer: queue.NewQueue(), lane: q.lane(PriorityNormal)}
+	paired.pairs[0].Add(2)
+	paired.pairs[1].Add(2)
+	state.Queue = paired
+
+	q.put(
+		Command{ID: "1", CMD: "CHECK"},
+		Command{ID: "2", CMD: "CHECK"},
+		Command{ID: "3", CMD: "CHECK"},
+		Command{ID: "4", CMD: "CHECK"},
+	)
+
+	// concurrent polls each leave command behind
+	var (
+		mu   sync.Mutex
+		sent = make(map[string]int)
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cmds, _ := q.poll(time.Now(), batchLimit{commands: 1})
+
+			mu.Lock()
+			for _, cmd := range cmds {
+				sent[cmd.ID]++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	cmds, _ := q.poll(time.Now(), batchLimit{})
+	for _, cmd := range cmds {
+		sent[cmd.ID]++
+	}
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if sent[id] != 1 {
+			t.Errorf("expected command %s sent once but sent %d times", id, sent[id])
+			t.FailNow()
+		}
+	}
+}
+
+func TestSharedQueueRetry(t *testing.T) {
+	state := &SharedState{
+		Queue:  queue.NewQueue(),
+		Store:  queue.NewStore(),
+		PubSub: queue.NewPubSub(),
+	}
+
+	q := &sharedQueue{state: state, sn: "123456789"}
+	q.put(
+		Command{ID: "1", CMD: "CHECK"},
+		Command{ID: "2", CMD: "REBOOT", Priority: PriorityUrgent, Retry: &RetryPolicy{MaxAttempts: 2, AckPolls: 1}},
+	)
+
+	cmds, _ := q.poll(time.Now(), batchLimit{commands: 1})
+	if len(cmds) != 1 || cmds[0].ID != "2" {
+		t.Errorf("expected urgent command first but returned %v", cmds)
+		t.FailNow()
+	}
+
+	// not acknowledged, re-delivered before pending command
+	cmds, _ = q.poll(time.Now(), batchLimit{commands: 1})
+	if len(cmds) != 1 || cmds[0].ID != "2" {
+		t.Errorf("expected re-delivered command but returned %v", cmds)
+		t.FailNow()
+	}
+
+	// max attempts reached
+	cmds, failed := q.poll(time.Now(), batchLimit{})
+	if len(failed) != 1 || failed[0].err != ErrCommandUnacknowledged {
+		t.Errorf("expected unacknowledged failure but returned %v", failed)
+		t.FailNow()
+	}
+
+	if len(cmds) != 1 || cmds[0].ID != "1" {
+		t.Errorf("expected remaining command but returned %v", cmds)
+	}
+}
+
+func TestSharedRegistryReplicas(t *testing.T) {
+	state := &SharedState{
+		Queue:  queue.NewQueue(),
+		Store:  queue.NewStore(),
+		PubSub: queue.NewPubSub(),
+		Locker: queue.NewLocker(),
+	}
+
+	a := NewServer(&ServerOption{SharedState: state})
+	b := NewServer(&ServerOption{SharedState: state})
+
+	a.RegisterDevice("123456789")
+
+	// fields updated by different replicas both kept
+	now := time.Now()
+	a.Registry().SetGroups("123456789", []string{"lobby"})
+	b.Registry().Touch("123456789", "10.0.0.1", now)
+	a.Registry().SetInfo("123456789", DeviceInfo{FirmwareVersion: "Ver 6.60"})
+
+	status, _ := b.Registry().Get("123456789")
+	if len(status.Groups) != 1 || status.IP != "10.0.0.1" || status.Info.FirmwareVersion != "Ver 6.60" || !status.LastSeen.Equal(now) {
+		t.Errorf("expected updates of both replicas but returned %+v", status)
+		t.FailNow()
+	}
+
+	// online reported once
+	if _, online := a.Registry().Touch("123456789", "", now); online {
+		t.Error("expected device already online")
+		t.FailNow()
+	}
+
+	// single replica run presence expiry
+	if !a.lead("presence", time.Minute) || b.lead("presence", time.Minute) {
+		t.Error("expected single presence runner")
+		t.FailNow()
+	}
+
+	if !a.lead("presence", time.Minute) {
+		t.Error("expected leader keep its lease")
+	}
+}
@@ -0,0 +1,201 @@
+package push
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Stamps hold last uploaded stamp of each table,
+// so device only upload newer data
+type Stamps struct {
+	AttLog   int `json:"attlog"`
+	OperLog  int `json:"operlog"`
+	AttPhoto int `json:"attphoto"`
+}
+
+// set update stamp of given table, return false
+// if table doesn't carry stamp
+func (s *Stamps) set(table string, stamp int) bool {
+	switch strings.ToUpper(table) {
+	case "ATTLOG":
+		s.AttLog = stamp
+	case "OPERLOG":
+		s.OperLog = stamp
+	case "ATTPHOTO":
+		s.AttPhoto = stamp
+	default:
+		return false
+	}
+
+	return true
+}
+
+// StampStore persist upload stamps of each device
+type StampStore interface {
+	// Get return stamps of device, zero stamps if never uploaded
+	Get(sn string) (Stamps, error)
+
+	// Set update stamp of device table
+	Set(sn string, table string, stamp int) error
+}
+
+// InMemoryStampStore implement StampStore using memory
+type InMemoryStampStore struct {
+	sync.Mutex
+
+	stamps map[string]Stamps
+}
+
+// Get implements StampStore.Get
+func (s *InMemoryStampStore) Get(sn string) (Stamps, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.stamps[sn], nil
+}
+
+// Set implements StampStore.Set
+func (s *InMemoryStampStore) Set(sn string, table string, stamp int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	stamps := s.stamps[sn]
+	if stamps.set(table, stamp) {
+		s.stamps[sn] = stamps
+	}
+
+	return nil
+}
+
+// NewStampStore create in memory stamp store
+func NewStampStore() *InMemoryStampStore {
+	return &InMemoryStampStore{stamps: make(map[string]Stamps)}
+}
+
+// FileStampStore implement StampStore using json file,
+// so stamps survive server restart
+type FileStampStore struct {
+	sync.Mutex
+
+	path   string
+	stamps map[string]Stamps
+}
+
+// Get implements StampStore.Get
+func (s *FileStampStore) Get(sn string) (Stamps, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.stamps[sn], nil
+}
+
+// Set implements StampStore.Set
+func (s *FileStampStore) Set(sn string, table string, stamp int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	stamps := s.stamps[sn]
+	if !stamps.set(table, stamp) || stamps == s.stamps[sn] {
+		return nil
+	}
+
+	s.stamps[sn] = stamps
+
+	return s.save()
+}
+
+// save write stamps into file. caller must hold lock
+func (s *FileStampStore) save() error {
+	b, err := json.MarshalIndent(s.stamps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, b)
+}
+
+// writeFileAtomic write data into temporary file then replace
+// the original, so it never left half written
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// NewFileStampStore open stamp store backed by given file.
+// file created on first update if not exists
+func NewFileStampStore(path string) (*FileStampStore, error) {
+	s := &FileStampStore{
+		path:   path,
+		stamps: make(map[string]Stamps),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.stamps); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// uploadStamp return stamp sent along with uploaded table
+func uploadStamp(query url.Values) (int, bool) {
+	for _, key := range []string{"Stamp", "OpStamp", "PhotoStamp"} {
+		if v, ok := query[key]; ok && len(v) > 0 {
+			stamp := value(v[0]).ToInt(-1)
+			return stamp, stamp != -1
+		}
+	}
+
+	return 0, false
+}
+
+// fillStamps set exchange stamps which not defined by hook
+// from last recorded stamps
+func (s *Server) fillStamps(sn string, cmd *ExchangeCommand) {
+	stamps, err := s.stamps.Get(sn)
+	if err != nil {
+		log.Printf("failed to get stamps of %s: %v\n", sn, err)
+		return
+	}
+
+	if cmd.AttLogStamp == 0 {
+		cmd.AttLogStamp = stamps.AttLog
+	}
+
+	if cmd.OperLogStamp == 0 {
+		cmd.OperLogStamp = stamps.OperLog
+	}
+
+	if cmd.AttPhotoStamp == 0 {
+		cmd.AttPhotoStamp = stamps.AttPhoto
+	}
+}
@@ -0,0 +1,40 @@
+package push
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStampStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stamps")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stamps.json")
+
+	store, err := NewFileStampStore(path)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	store.Set("123456789", "ATTLOG", 100)
+	store.Set("123456789", "OPERLOG", 200)
+
+	// reopen, simulate restart
+	store, err = NewFileStampStore(path)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	stamps, _ := store.Get("123456789")
+	if stamps.AttLog != 100 || stamps.OperLog != 200 {
+		t.Errorf("unexpected stamps %+v", stamps)
+	}
+}
@@ -0,0 +1,85 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPIN returned when pin empty or contains separator
+var ErrInvalidPIN = errors.New("PIN must not be empty or contain whitespace")
+
+// event type of uploaded user information
+const EventUser = "user"
+
+// record prefix of user information on operation log upload
+var userPrefix = []byte("USER ")
+
+// UserHook define callback upon receiving
+// user information uploaded by device
+type UserHook interface {
+	OnUser(sn string, user User)
+}
+
+// User represent user information stored on device
+type User struct {
+	PIN       string `json:"pin"`
+	Name      string `json:"name"`
+	Privilege int    `json:"privilege"`
+	Password  string `json:"password,omitempty"`
+	Card      string `json:"card,omitempty"`
+	Group     int    `json:"group"`
+
+	// time zone of access control
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// validPIN check whether pin can be written on command,
+// separator would inject another field or command
+func validPIN(pin string) bool {
+	return pin != "" && !strings.ContainsAny(pin, " \t\r\n")
+}
+
+// Unmarshall implement payload.Unmarshall interface
+func (u *User) Unmarshall(b []byte) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(bytes.TrimPrefix(b, userPrefix), "\t")
+
+	u.PIN = fields["PIN"]
+	u.Name = fields["Name"]
+	u.Privilege = value(fields["Pri"]).ToInt(0)
+	u.Password = fields["Passwd"]
+	u.Card = fields["Card"]
+	u.Group = value(fields["Grp"]).ToInt(0)
+	u.TimeZone = fields["TZ"]
+
+	return nil
+}
+
+// Marshall implement payload.Marshall interface
+func (u User) Marshall() ([]byte, error) {
+	return []byte(fmt.Sprintf("PIN=%s\tName=%s\tPri=%d\tPasswd=%s\tCard=%s\tGrp=%d\tTZ=%s",
+		u.PIN, u.Name, u.Privilege, u.Password, u.Card, u.Group, u.TimeZone)), nil
+}
+
+func (s *Server) uploadUser(sn string, line []byte) error {
+	var user User
+	if err := Unmarshall(line, &user); err != nil {
+		return err
+	}
+
+	// call hook
+	if s.hook != nil {
+		if hook, ok := s.hook.(UserHook); ok {
+			hook.OnUser(sn, user)
+		}
+	}
+
+	s.publish(EventUser, sn, user)
+
+	return nil
+}
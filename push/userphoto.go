@@ -0,0 +1,169 @@
+package push
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// record prefix on operation log upload
+var (
+	userPhotoPrefix = []byte("USERPIC ")
+	bioPhotoPrefix  = []byte("BIOPHOTO ")
+)
+
+// bio photo type of visible light face
+const BioPhotoFace = 9
+
+// UserPhotoHook define callback upon receiving user photo
+// or bio photo uploaded by device
+type UserPhotoHook interface {
+	OnUserPhoto(sn string, photo UserPhoto)
+	OnBioPhoto(sn string, photo BioPhoto)
+}
+
+// decodePhotoContent decode base64 photo content and validate
+// its size, which is length of encoded content
+func decodePhotoContent(fields map[string]string) ([]byte, error) {
+	content := fields["Content"]
+
+	if size, err := strconv.Atoi(fields["Size"]); err == nil && size != len(content) {
+		return nil, ErrUserPhotoSizeMismatch
+	}
+
+	return base64.StdEncoding.DecodeString(content)
+}
+
+// UserPhoto represent user profile photo
+type UserPhoto struct {
+	PIN      string `json:"pin"`
+	FileName string `json:"file_name"`
+
+	// jpeg image
+	Image []byte `json:"image"`
+}
+
+// Unmarshall implement payload.Unmarshall interface
+func (p *UserPhoto) Unmarshall(b []byte) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(bytes.TrimPrefix(b, userPhotoPrefix), "\t")
+
+	image, err := decodePhotoContent(fields)
+	if err != nil {
+		return err
+	}
+
+	p.PIN = fields["PIN"]
+	p.FileName = fields["FileName"]
+	p.Image = image
+
+	return nil
+}
+
+// Marshall implement payload.Marshall interface
+func (p UserPhoto) Marshall() ([]byte, error) {
+	if !validPIN(p.PIN) {
+		return nil, ErrInvalidPIN
+	}
+
+	content := base64.StdEncoding.EncodeToString(p.Image)
+
+	return []byte(fmt.Sprintf("PIN=%s\tSize=%d\tContent=%s", p.PIN, len(content), content)), nil
+}
+
+// BioPhoto represent photo which used by device
+// to extract biometric template, e.g. face
+type BioPhoto struct {
+	PIN      string `json:"pin"`
+	FileName string `json:"file_name"`
+
+	// biometric type (see BioPhotoFace)
+	Type int `json:"type"`
+
+	// jpeg image
+	Image []byte `json:"image"`
+}
+
+// Unmarshall implement payload.Unmarshall interface
+func (p *BioPhoto) Unmarshall(b []byte) error {
+	if len(b) == 0 {
+		return ErrEmptyPayload
+	}
+
+	fields := parseOptions(bytes.TrimPrefix(b, bioPhotoPrefix), "\t")
+
+	image, err := decodePhotoContent(fields)
+	if err != nil {
+		return err
+	}
+
+	p.PIN = fields["PIN"]
+	p.FileName = fields["FileName"]
+	p.Type = value(fields["Type"]).ToInt()
+	p.Image = image
+
+	return nil
+}
+
+// Marshall implement payload.Marshall interface
+func (p BioPhoto) Marshall() ([]byte, error) {
+	if !validPIN(p.PIN) {
+		return nil, ErrInvalidPIN
+	}
+
+	content := base64.StdEncoding.EncodeToString(p.Image)
+
+	return []byte(fmt.Sprintf("PIN=%s\tType=%d\tSize=%d\tContent=%s\tFormat=0\tUrl=",
+		p.PIN, p.Type, len(content), content)), nil
+}
+
+// UpdateUserPhotoCommand create command which upload user photo to device
+func UpdateUserPhotoCommand(photo UserPhoto) (Command, error) {
+	if len(photo.Image) == 0 {
+		return Command{}, ErrEmptyPayload
+	}
+
+	b, err := photo.Marshall()
+	if err != nil {
+		return Command{}, err
+	}
+
+	return Command{CMD: "DATA UPDATE USERPIC", Payload: b}, nil
+}
+
+// DeleteUserPhotoCommand create command which remove user photo from device
+func DeleteUserPhotoCommand(pin string) (Command, error) {
+	if !validPIN(pin) {
+		return Command{}, ErrInvalidPIN
+	}
+
+	return Command{CMD: "DATA DELETE USERPIC", Payload: []byte("PIN=" + pin)}, nil
+}
+
+// UpdateBioPhotoCommand create command which upload bio photo to device.
+// device extract biometric template from the photo
+func UpdateBioPhotoCommand(photo BioPhoto) (Command, error) {
+	if len(photo.Image) == 0 {
+		return Command{}, ErrEmptyPayload
+	}
+
+	b, err := photo.Marshall()
+	if err != nil {
+		return Command{}, err
+	}
+
+	return Command{CMD: "DATA UPDATE BIOPHOTO", Payload: b}, nil
+}
+
+// DeleteBioPhotoCommand create command which remove bio photo from device
+func DeleteBioPhotoCommand(pin string, kind int) (Command, error) {
+	if !validPIN(pin) {
+		return Command{}, ErrInvalidPIN
+	}
+
+	return Command{CMD: "DATA DELETE BIOPHOTO", Payload: []byte(fmt.Sprintf("PIN=%s\tType=%d", pin, kind))}, nil
+}
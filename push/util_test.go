@@ -31,3 +31,20 @@ func TestExtractValue(t *testing.T) {
 		}
 	}
 }
+
+func TestDeviceInfoUnmarshall(t *testing.T) {
+	var info DeviceInfo
+	if err := Unmarshall([]byte("Ver 6.60 Apr 28 2017,20,35,1024,192.168.1.201,10,7,12,3,101"), &info); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if info.FirmwareVersion != "Ver 6.60 Apr 28 2017" || info.AttLogCount != 1024 || info.IP != "192.168.1.201" {
+		t.Errorf("unexpected info %+v", info)
+		t.FailNow()
+	}
+
+	if !info.Supports(FeatureFingerPrint) || info.Supports(FeatureFace) || !info.Supports(FeatureUserPhoto) {
+		t.Errorf("unexpected features %s", info.Features)
+	}
+}
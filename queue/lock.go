@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Locker represent lease based lock, e.g. to elect single
+// replica which run periodic job
+type Locker interface {
+
+	// Lock acquire lock for owner or extend lease when already
+	// held by owner. return false when held by other owner.
+	// lock released when lease not extended within ttl
+	Lock(name string, owner string, ttl time.Duration) (bool, error)
+}
+
+// InMemoryLocker implement Locker using memory,
+// only exclusive within single process
+type InMemoryLocker struct {
+	mu sync.Mutex
+
+	leases map[string]lease
+}
+
+type lease struct {
+	owner   string
+	expires time.Time
+}
+
+// Lock implements Locker.Lock
+func (l *InMemoryLocker) Lock(name string, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if current, ok := l.leases[name]; ok && current.owner != owner && now.Before(current.expires) {
+		return false, nil
+	}
+
+	l.leases[name] = lease{owner: owner, expires: now.Add(ttl)}
+
+	return true, nil
+}
+
+// NewLocker create in memory locker
+func NewLocker() Locker {
+	return &InMemoryLocker{leases: make(map[string]lease)}
+}
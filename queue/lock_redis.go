@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"time"
+
+	driver "github.com/gomodule/redigo/redis"
+)
+
+// extend lease of owner, otherwise acquire when free
+var lockScript = driver.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// RedisLocker implement Locker using redis keys with expiry
+type RedisLocker struct {
+	pool *driver.Pool
+}
+
+// Lock implements Locker.Lock
+func (l *RedisLocker) Lock(name string, owner string, ttl time.Duration) (bool, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	n, err := driver.Int(lockScript.Do(conn, name, owner, int64(ttl/time.Millisecond)))
+
+	return n == 1, err
+}
+
+// NewRedisLocker init new locker which backed by redis
+func NewRedisLocker(option *RedisOption) Locker {
+	pool, err := open(defaultRedisOption(option))
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisLocker{pool: pool}
+}
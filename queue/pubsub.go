@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// PubSub represent publish / subscribe messaging
+// messages divided by channel name
+type PubSub interface {
+
+	// Publish send message to current subscribers of channel
+	Publish(channel string, message string) error
+
+	// Subscribe receive messages of channel until context cancelled,
+	// then returned go channel closed
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// InMemoryPubSub implement PubSub using memory,
+// only deliver messages within single process
+type InMemoryPubSub struct {
+	sync.RWMutex
+
+	subscribers map[string]map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ctx context.Context
+	ch  chan string
+}
+
+// Publish implements PubSub.Publish. never block publisher,
+// message dropped for subscriber which buffer is full
+func (p *InMemoryPubSub) Publish(channel string, message string) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	for sub := range p.subscribers[channel] {
+		select {
+		case sub.ch <- message:
+		default:
+			log.Printf("message of %s dropped, subscriber too slow\n", channel)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe implements PubSub.Subscribe
+func (p *InMemoryPubSub) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	sub := &subscriber{
+		ctx: ctx,
+		ch:  make(chan string, 64),
+	}
+
+	p.Lock()
+	if _, ok := p.subscribers[channel]; !ok {
+		p.subscribers[channel] = make(map[*subscriber]struct{})
+	}
+	p.subscribers[channel][sub] = struct{}{}
+	p.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		p.Lock()
+		delete(p.subscribers[channel], sub)
+		close(sub.ch)
+		p.Unlock()
+	}()
+
+	return sub.ch, nil
+}
+
+// NewPubSub create in memory pub sub
+func NewPubSub() PubSub {
+	return &InMemoryPubSub{subscribers: make(map[string]map[*subscriber]struct{})}
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"log"
+
+	driver "github.com/gomodule/redigo/redis"
+)
+
+// RedisPubSub implement PubSub using redis channels
+type RedisPubSub struct {
+	pool *driver.Pool
+}
+
+// Publish implements PubSub.Publish
+func (p *RedisPubSub) Publish(channel string, message string) error {
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PUBLISH", channel, message)
+
+	return err
+}
+
+// Subscribe implements PubSub.Subscribe
+func (p *RedisPubSub) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	conn := driver.PubSubConn{Conn: p.pool.Get()}
+	if err := conn.Subscribe(channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// unblock receive when cancelled
+	go func() {
+		<-ctx.Done()
+		conn.Unsubscribe()
+		conn.Close()
+	}()
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+
+		for {
+			switch v := conn.Receive().(type) {
+			case driver.Message:
+				select {
+				case ch <- string(v.Data):
+				case <-ctx.Done():
+					return
+				}
+			case driver.Subscription:
+				if v.Count == 0 {
+					return
+				}
+			case error:
+				if ctx.Err() == nil {
+					log.Printf("redis subscription %s stopped: %v\n", channel, v)
+				}
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// NewRedisPubSub init new pub sub which backed by redis
+func NewRedisPubSub(option *RedisOption) PubSub {
+	pool, err := open(defaultRedisOption(option))
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisPubSub{pool: pool}
+}
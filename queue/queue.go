@@ -114,7 +114,7 @@ func (q *internalQueue) pop() (string, error) {
 	q.Lock()
 	defer q.Unlock()
 
-	if q.items == nil {
+	if len(q.items) == 0 {
 		return "", ErrQueueEmpty
 	}
 
@@ -71,6 +71,17 @@ func (q *RedisQueue) Len(queueName string) int {
 
 // NewRedisQueue init new queue which backed by redis
 func NewRedisQueue(option *RedisOption) Queuer {
+	// init pool
+	pool, err := open(defaultRedisOption(option))
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisQueue{pool: pool}
+}
+
+// defaultRedisOption fill default of missing configuration
+func defaultRedisOption(option *RedisOption) *RedisOption {
 	if option == nil {
 		option = &RedisOption{}
 	}
@@ -79,13 +90,7 @@ func NewRedisQueue(option *RedisOption) Queuer {
 		option.Host = "localhost:6379"
 	}
 
-	// init pool
-	pool, err := open(option)
-	if err != nil {
-		panic(err)
-	}
-
-	return &RedisQueue{pool: pool}
+	return option
 }
 
 // open connection
@@ -1,6 +1,10 @@
 package queue
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 // In memory queue test
 func TestQueue(t *testing.T) {
@@ -43,3 +47,143 @@ func TestQueue(t *testing.T) {
 		t.Error("Expected item ITEM1 but returned", item)
 	}
 }
+
+// In memory store test
+func TestStore(t *testing.T) {
+	store := NewStore()
+
+	store.Set("TEST", "KEY1", "VALUE1")
+
+	if v, _ := store.Get("TEST", "KEY1"); v != "VALUE1" {
+		t.Error("Expected value VALUE1 but returned", v)
+		t.FailNow()
+	}
+
+	// only first delete claim the key
+	if ok, _ := store.Delete("TEST", "KEY1"); !ok {
+		t.Error("Expected key deleted")
+		t.FailNow()
+	}
+
+	if ok, _ := store.Delete("TEST", "KEY1"); ok {
+		t.Error("Expected key already deleted")
+		t.FailNow()
+	}
+
+	if _, err := store.Get("TEST", "KEY1"); err != ErrKeyNotFound {
+		t.Error("Expected key not found but returned", err)
+	}
+}
+
+// In memory pub sub test
+func TestPubSub(t *testing.T) {
+	pubsub := NewPubSub()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, _ := pubsub.Subscribe(ctx, "TEST")
+
+	pubsub.Publish("TEST", "MESSAGE1")
+
+	if message := <-messages; message != "MESSAGE1" {
+		t.Error("Expected message MESSAGE1 but returned", message)
+	}
+
+	// channel closed when unsubscribed
+	cancel()
+	for range messages {
+	}
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	pubsub := NewPubSub()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, _ := pubsub.Subscribe(ctx, "TEST")
+
+	// publisher not blocked by full buffer
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			pubsub.Publish("TEST", "MESSAGE")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Error("publisher blocked by slow subscriber")
+		t.FailNow()
+	}
+
+	if message := <-messages; message != "MESSAGE" {
+		t.Error("Expected message MESSAGE but returned", message)
+	}
+}
+
+func TestLocker(t *testing.T) {
+	locker := NewLocker()
+
+	if ok, _ := locker.Lock("TEST", "A", time.Millisecond*50); !ok {
+		t.Error("Expected lock acquired")
+		t.FailNow()
+	}
+
+	if ok, _ := locker.Lock("TEST", "B", time.Millisecond*50); ok {
+		t.Error("Expected lock held by other owner")
+		t.FailNow()
+	}
+
+	// lease extended by owner
+	if ok, _ := locker.Lock("TEST", "A", time.Millisecond*50); !ok {
+		t.Error("Expected lease extended")
+		t.FailNow()
+	}
+
+	// released after lease expired
+	time.Sleep(time.Millisecond * 60)
+	if ok, _ := locker.Lock("TEST", "B", time.Millisecond*50); !ok {
+		t.Error("Expected lock acquired after lease expired")
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	store := NewStore()
+
+	// required key missing
+	if _, ok, _ := store.Update("TEST", HashUpdate{Require: "KEY1", Set: map[string]string{"KEY2": "VALUE2"}}); ok {
+		t.Error("Expected update skipped without required key")
+		t.FailNow()
+	}
+
+	store.Set("TEST", "KEY1", "VALUE1")
+	store.Set("TEST", "KEY3", "VALUE3")
+
+	before, ok, err := store.Update("TEST", HashUpdate{
+		Require: "KEY1",
+		Match:   map[string]string{"KEY3": "VALUE3"},
+		Set:     map[string]string{"KEY2": "VALUE2"},
+		Delete:  []string{"KEY3"},
+	})
+	if err != nil || !ok || before["KEY3"] != "VALUE3" || before["KEY2"] != "" {
+		t.Error("Expected update applied but returned", before, ok, err)
+		t.FailNow()
+	}
+
+	if v, _ := store.Get("TEST", "KEY2"); v != "VALUE2" {
+		t.Error("Expected value VALUE2 but returned", v)
+		t.FailNow()
+	}
+
+	// matched value changed
+	if _, ok, _ := store.Update("TEST", HashUpdate{Match: map[string]string{"KEY3": "VALUE3"}, Delete: []string{"KEY1"}}); ok {
+		t.Error("Expected update skipped on changed value")
+		t.FailNow()
+	}
+
+	if _, err := store.Get("TEST", "KEY1"); err != nil {
+		t.Error("Expected key kept but returned", err)
+	}
+}
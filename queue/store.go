@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrKeyNotFound returned when key doesn't exist in store
+var ErrKeyNotFound = errors.New("key not found")
+
+// Store represent simple key value store
+// keys will divided by hash name
+// eg:
+// Hash 1: {key1: value1, key2: value2}
+// Hash 2: {key3: value3}
+type Store interface {
+
+	// Set insert or replace value of key in hash
+	Set(name string, key string, value string) error
+
+	// Get value of key in hash
+	// if key not found then return "" + ErrKeyNotFound
+	Get(name string, key string) (string, error)
+
+	// Delete remove key from hash, return true if key existed.
+	// only single caller get true on concurrent delete,
+	// so it can be used to claim item
+	Delete(name string, key string) (bool, error)
+
+	// All get every key value in hash
+	All(name string) (map[string]string, error)
+
+	// Update apply changes on hash atomically when its conditions
+	// hold, returns content of hash before update and whether
+	// changes applied
+	Update(name string, update HashUpdate) (map[string]string, bool, error)
+}
+
+// HashUpdate is changes applied atomically on single hash
+type HashUpdate struct {
+	// applied only when hash has key of Require, empty means no check
+	Require string
+
+	// applied only when keys hold given values
+	Match map[string]string
+
+	Set    map[string]string
+	Delete []string
+}
+
+// holds check whether conditions of update hold on hash
+func (u HashUpdate) holds(hash map[string]string) bool {
+	if _, ok := hash[u.Require]; u.Require != "" && !ok {
+		return false
+	}
+
+	for key, value := range u.Match {
+		if v, ok := hash[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// InMemoryStore implement Store using memory
+type InMemoryStore struct {
+	sync.Mutex
+
+	hashes map[string]map[string]string
+}
+
+// Set implements Store.Set
+func (s *InMemoryStore) Set(name string, key string, value string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	hash, ok := s.hashes[name]
+	if !ok {
+		hash = make(map[string]string)
+		s.hashes[name] = hash
+	}
+
+	hash[key] = value
+
+	return nil
+}
+
+// Get implements Store.Get
+func (s *InMemoryStore) Get(name string, key string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.hashes[name][key]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+
+	return value, nil
+}
+
+// Delete implements Store.Delete
+func (s *InMemoryStore) Delete(name string, key string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.hashes[name][key]; !ok {
+		return false, nil
+	}
+
+	delete(s.hashes[name], key)
+
+	return true, nil
+}
+
+// All implements Store.All
+func (s *InMemoryStore) All(name string) (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	all := make(map[string]string, len(s.hashes[name]))
+	for key, value := range s.hashes[name] {
+		all[key] = value
+	}
+
+	return all, nil
+}
+
+// Update implements Store.Update
+func (s *InMemoryStore) Update(name string, update HashUpdate) (map[string]string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	before := make(map[string]string, len(s.hashes[name]))
+	for key, value := range s.hashes[name] {
+		before[key] = value
+	}
+
+	if !update.holds(before) {
+		return before, false, nil
+	}
+
+	hash, ok := s.hashes[name]
+	if !ok {
+		hash = make(map[string]string)
+		s.hashes[name] = hash
+	}
+
+	for key, value := range update.Set {
+		hash[key] = value
+	}
+
+	for _, key := range update.Delete {
+		delete(hash, key)
+	}
+
+	return before, true, nil
+}
+
+// NewStore create in memory store
+func NewStore() Store {
+	return &InMemoryStore{hashes: make(map[string]map[string]string)}
+}
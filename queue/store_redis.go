@@ -0,0 +1,134 @@
+package queue
+
+import (
+	driver "github.com/gomodule/redigo/redis"
+)
+
+// apply update when conditions hold, return applied flag followed
+// by hash content before update. arguments are required key, count
+// and pairs of matched values, count and pairs of set values,
+// then deleted keys
+var updateScript = driver.NewScript(1, `
+local hash = redis.call("HGETALL", KEYS[1])
+local before = {}
+for i = 1, #hash, 2 do
+	before[hash[i]] = hash[i + 1]
+end
+local i = 1
+if ARGV[i] ~= "" and before[ARGV[i]] == nil then
+	return {0, hash}
+end
+i = i + 1
+local n = tonumber(ARGV[i])
+i = i + 1
+for j = 1, n do
+	if before[ARGV[i]] ~= ARGV[i + 1] then
+		return {0, hash}
+	end
+	i = i + 2
+end
+n = tonumber(ARGV[i])
+i = i + 1
+for j = 1, n do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+	i = i + 2
+end
+for j = i, #ARGV do
+	redis.call("HDEL", KEYS[1], ARGV[j])
+end
+return {1, hash}
+`)
+
+// RedisStore implement Store using redis hashes
+type RedisStore struct {
+	pool *driver.Pool
+}
+
+// Set implements Store.Set
+func (s *RedisStore) Set(name string, key string, value string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HSET", name, key, value)
+
+	return err
+}
+
+// Get implements Store.Get
+func (s *RedisStore) Get(name string, key string) (string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	value, err := driver.String(conn.Do("HGET", name, key))
+	if err == driver.ErrNil {
+		return "", ErrKeyNotFound
+	}
+
+	return value, err
+}
+
+// Delete implements Store.Delete
+func (s *RedisStore) Delete(name string, key string) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	n, err := driver.Int(conn.Do("HDEL", name, key))
+
+	return n > 0, err
+}
+
+// All implements Store.All
+func (s *RedisStore) All(name string) (map[string]string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return driver.StringMap(conn.Do("HGETALL", name))
+}
+
+// Update implements Store.Update
+func (s *RedisStore) Update(name string, update HashUpdate) (map[string]string, bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{name, update.Require, len(update.Match)}
+	for key, value := range update.Match {
+		args = append(args, key, value)
+	}
+
+	args = append(args, len(update.Set))
+	for key, value := range update.Set {
+		args = append(args, key, value)
+	}
+
+	for _, key := range update.Delete {
+		args = append(args, key)
+	}
+
+	reply, err := driver.Values(updateScript.Do(conn, args...))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(reply) != 2 {
+		return nil, false, driver.ErrNil
+	}
+
+	applied, err := driver.Int(reply[0], nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	before, err := driver.StringMap(reply[1], nil)
+
+	return before, applied == 1, err
+}
+
+// NewRedisStore init new store which backed by redis
+func NewRedisStore(option *RedisOption) Store {
+	pool, err := open(defaultRedisOption(option))
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisStore{pool: pool}
+}
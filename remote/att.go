@@ -3,7 +3,7 @@ package remote
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
+	"time"
 )
 
 // VerificationKind supported by device
@@ -16,10 +16,18 @@ const (
 	VerifyWithCard
 )
 
+// layout of attendance time string
+const attLogTimeLayout = "2006/01/02 15:04:05"
+
 type EventAttLog struct {
 	UID              string
 	VerificationKind VerificationKind
-	DateString       string
+
+	// attendance time on location of device clock
+	Time time.Time
+
+	// attendance time formatted as yyyy/mm/dd hh:mm:ss
+	DateString string
 }
 
 // EventAttLogFromEvent decode att log event from event payload
@@ -30,19 +38,28 @@ func EventAttLogFromEvent(evt Event) (EventAttLog, error) {
 
 	uid := string(evt.Data[0:9])
 	verificationKind := VerificationKind(binary.LittleEndian.Uint16(evt.Data[24:26]))
-	dateString := fmt.Sprintf("20%d/%d/%d %02d:%02d:%02d",
-		evt.Data[26],
-		evt.Data[27],
-		evt.Data[28],
-		evt.Data[29],
-		evt.Data[30],
-		evt.Data[31],
+
+	loc := evt.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	t := time.Date(
+		2000+int(evt.Data[26]),
+		time.Month(evt.Data[27]),
+		int(evt.Data[28]),
+		int(evt.Data[29]),
+		int(evt.Data[30]),
+		int(evt.Data[31]),
+		0,
+		loc,
 	)
 
 	return EventAttLog{
 		UID:              uid,
 		VerificationKind: verificationKind,
-		DateString:       dateString,
+		Time:             t,
+		DateString:       t.Format(attLogTimeLayout),
 	}, nil
 
 }
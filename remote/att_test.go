@@ -0,0 +1,34 @@
+package remote
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventAttLogFromEvent(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*3600)
+
+	data := make([]byte, 32)
+	copy(data, "12")
+	data[24] = VerifyWithFingerPrint
+	copy(data[26:], []byte{19, 5, 2, 8, 3, 9})
+
+	attlog, err := EventAttLogFromEvent(Event{Type: EfAttlog, Data: data, Location: jakarta})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	expected := time.Date(2019, 5, 2, 8, 3, 9, 0, jakarta)
+	if !attlog.Time.Equal(expected) || attlog.Time.Location() != jakarta {
+		t.Errorf("expected %v but returned %v", expected, attlog.Time)
+	}
+
+	if attlog.DateString != "2019/05/02 08:03:09" {
+		t.Errorf("expected padded date string but returned %s", attlog.DateString)
+	}
+
+	if attlog.VerificationKind != VerifyWithFingerPrint {
+		t.Errorf("unexpected verification kind %d", attlog.VerificationKind)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"errors"
 	"net"
+	"time"
 )
 
 type Event struct {
 	Type uint16
 	Data []byte
+
+	// location of device clock, nil means local time
+	Location *time.Location
 }
 
 type EventListener struct {
@@ -76,8 +80,9 @@ func (e *EventListener) Listen(ctx context.Context) (<-chan Event, error) {
 				}
 
 				ch <- Event{
-					Type: response.session,
-					Data: response.data,
+					Type:     response.session,
+					Data:     response.data,
+					Location: e.t.Location(),
 				}
 			}
 		}
@@ -23,6 +23,9 @@ type Terminal struct {
 	address string
 	timeout time.Duration
 
+	// location of device clock, nil means local time
+	location *time.Location
+
 	// state
 	conn net.Conn
 
@@ -351,6 +354,21 @@ func (t *Terminal) Disable(vars ...time.Duration) error {
 	return nil
 }
 
+// SetLocation set location of device clock, used on
+// device time and timestamp of realtime events
+func (t *Terminal) SetLocation(loc *time.Location) {
+	t.location = loc
+}
+
+// Location return location of device clock
+func (t *Terminal) Location() *time.Location {
+	if t.location == nil {
+		return time.Local
+	}
+
+	return t.location
+}
+
 // GetTime return decoded time of the device
 func (t *Terminal) GetTime() time.Time {
 	var response Packet
@@ -358,7 +376,7 @@ func (t *Terminal) GetTime() time.Time {
 		return time.Time{}
 	}
 
-	return decodeTime(response.data)
+	return decodeTime(response.data, t.Location())
 }
 
 // SetTime set time of device
@@ -368,7 +386,7 @@ func (t *Terminal) SetTime(datetime time.Time) error {
 	}
 
 	var response Packet
-	if err := t.SendAndReceive(CmdSetTime, encodeTime(datetime), &response); err != nil {
+	if err := t.SendAndReceive(CmdSetTime, encodeTime(datetime, t.Location()), &response); err != nil {
 		return err
 	}
 
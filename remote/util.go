@@ -41,25 +41,39 @@ func checksum(payload []byte) uint16 {
 }
 
 // decodeTime cecodes time, as given on ZKTeco get/set time commands.
-// param: raw data with the time field stored in little endian.
+// param: raw data with the time field stored in little endian,
+// and location of device clock.
 // return: time.Time, with the extracted date.
-func decodeTime(raw []byte) time.Time {
-	// extract time value
-	t := uint(binary.LittleEndian.Uint64(raw))
+func decodeTime(raw []byte, loc *time.Location) time.Time {
+	if len(raw) < 4 {
+		return time.Time{}
+	}
+
+	// extract time value, device send 4 bytes
+	t := uint(binary.LittleEndian.Uint32(raw))
 
 	Println("raw", t)
 
+	// every month counted as 31 days
 	second := int(t % 60)
-	minute := int((t / 60) % 60)
-	hour := int((t / 3600) % 24)
-	day := int((t / (3600 * 24) % 31)) + 1
-	month := int((t / (3600 * 24 * 31) % 12)) + 1
-	year := int((t/(3600*24))/365) + 2000
-
-	return time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	t /= 60
+	minute := int(t % 60)
+	t /= 60
+	hour := int(t % 24)
+	t /= 24
+	day := int(t%31) + 1
+	t /= 31
+	month := int(t%12) + 1
+	t /= 12
+	year := int(t) + 2000
+
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
 }
 
-func encodeTime(t time.Time) []byte {
+// encodeTime encode time as wall clock of device location
+func encodeTime(t time.Time, loc *time.Location) []byte {
+	t = t.In(loc)
+
 	b := make([]byte, 8)
 
 	v := ((t.Year()%100)*12*31+
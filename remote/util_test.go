@@ -47,15 +47,18 @@ func TestChecksum(t *testing.T) {
 func TestEncodeDecodeTime(t *testing.T) {
 	SetVerbose()
 
+	jakarta := time.FixedZone("WIB", 7*3600)
+
 	var testCases = []time.Time{
-		time.Date(2018, 1, 1, 0, 0, 0, 0, time.Local),
-		time.Date(2019, 12, 31, 12, 05, 0, 0, time.Local),
-		time.Now(),
+		time.Date(2018, 1, 1, 0, 0, 0, 0, jakarta),
+		time.Date(2019, 12, 31, 12, 05, 0, 0, jakarta),
+		time.Date(2019, 12, 31, 20, 05, 0, 0, time.UTC),
+		time.Now().Truncate(time.Second),
 	}
 
 	for _, tc := range testCases {
-		encoded := encodeTime(tc)
-		res := decodeTime(encoded)
+		encoded := encodeTime(tc, jakarta)
+		res := decodeTime(encoded, jakarta)
 
 		if !res.Equal(tc) {
 			t.Errorf("expected %v but returned %v", tc, res)
@@ -63,4 +66,10 @@ func TestEncodeDecodeTime(t *testing.T) {
 		}
 
 	}
+
+	// device clock keep wall clock of its location
+	res := decodeTime(encodeTime(time.Date(2019, 12, 31, 20, 05, 0, 0, time.UTC), jakarta), jakarta)
+	if res.Day() != 1 || res.Hour() != 3 || res.Location() != jakarta {
+		t.Errorf("expected wall clock on device location but returned %v", res)
+	}
 }
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/galihrivanto/go-zk/push"
+	"github.com/galihrivanto/go-zk/remote"
+)
+
+// event type of realtime event which not decoded
+const EventRealtime = "realtime"
+
+// RealtimeEvent is data of undecoded realtime event
+type RealtimeEvent struct {
+	Type uint16 `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// ForwardPush forward push server events which pass the filter,
+// blocking until context cancelled or event bus closed. subscribed
+// as lossless, so burst of uploaded records wait to be enqueued
+func (f *Forwarder) ForwardPush(ctx context.Context, bus *push.EventBus, filter push.EventFilter) {
+	sub := bus.SubscribeLossless(filter, 0)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			f.forward(Event{
+				Type: e.Type,
+				SN:   e.SN,
+				Time: e.Time,
+				Data: e.Data,
+			})
+		}
+	}
+}
+
+// ForwardRemote forward realtime events of terminal, e.g. from
+// remote.EventListener.Listen. attendance log decoded, other
+// events forwarded as is. blocking until events channel closed
+// or context cancelled
+func (f *Forwarder) ForwardRemote(ctx context.Context, sn string, events <-chan remote.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+
+			e := Event{
+				SN:   sn,
+				Time: time.Now(),
+			}
+
+			if evt.Type == remote.EfAttlog {
+				attlog, err := remote.EventAttLogFromEvent(evt)
+				if err != nil {
+					log.Printf("failed to decode att log of %s: %v\n", sn, err)
+					continue
+				}
+
+				e.Type = push.EventAttendance
+				e.Data = attlog
+			} else {
+				e.Type = EventRealtime
+				e.Data = RealtimeEvent{Type: evt.Type, Data: evt.Data}
+			}
+
+			f.forward(e)
+		}
+	}
+}
+
+// forward enqueue event, error only logged as
+// event source can't handle it
+func (f *Forwarder) forward(e Event) {
+	if err := f.Forward(e); err != nil {
+		log.Printf("failed to forward %s event of %s: %v\n", e.Type, e.SN, err)
+	}
+}
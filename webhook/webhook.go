@@ -0,0 +1,438 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/galihrivanto/go-zk/queue"
+	uuid "github.com/satori/go.uuid"
+)
+
+// request headers sent along with event
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-ID"
+)
+
+// default forwarder setting
+const (
+	defaultMaxAttempts  = 5
+	defaultBackoff      = time.Second
+	defaultMaxBackoff   = time.Minute
+	defaultTimeout      = time.Second * 10
+	defaultPollInterval = time.Second
+	defaultQueueName    = "webhook"
+)
+
+// forwarder error
+var (
+	ErrNoEndpoint       = errors.New("No webhook endpoint configured")
+	ErrDeliveryRejected = errors.New("Webhook delivery rejected")
+)
+
+// Event is json body posted to endpoints
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	SN   string      `json:"sn"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Endpoint is destination of events
+type Endpoint struct {
+	URL string
+
+	// key of HMAC-SHA256 body signature,
+	// no signature header when empty
+	Secret string
+
+	// event types forwarded to endpoint, empty means all
+	Types []string
+}
+
+// accept check whether endpoint subscribe event type
+func (e Endpoint) accept(eventType string) bool {
+	if len(e.Types) == 0 {
+		return true
+	}
+
+	for _, t := range e.Types {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Option define forwarder configuration
+type Option struct {
+	Endpoints []Endpoint
+
+	// delivery attempts before parked in dead letter
+	MaxAttempts int
+
+	// delay before first retry, doubled on each
+	// next retry up to max backoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// http request timeout
+	Timeout time.Duration
+
+	// number of concurrent delivery workers
+	Workers int
+
+	// storage of pending and dead letter deliveries,
+	// default in memory. use redis queue to survive restart
+	Queue queue.Queuer
+
+	// prefix of queue names
+	QueueName string
+
+	Client *http.Client
+}
+
+// delivery is single event to be posted to endpoint
+type delivery struct {
+	URL      string          `json:"url"`
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// DeadLetter describe delivery which failed after all attempts
+type DeadLetter struct {
+	ID       string
+	Type     string
+	URL      string
+	Attempts int
+	Error    string
+	Body     []byte
+}
+
+// Forwarder post events to webhook endpoints in background
+type Forwarder struct {
+	option *Option
+	queue  queue.Queuer
+	client *http.Client
+
+	// pending and dead letter queue name
+	pending    string
+	deadLetter string
+
+	// serialize dead letter access
+	lock sync.Mutex
+}
+
+// New create forwarder with given option
+func New(option *Option) *Forwarder {
+	if option.MaxAttempts < 1 {
+		option.MaxAttempts = defaultMaxAttempts
+	}
+
+	if option.Backoff <= 0 {
+		option.Backoff = defaultBackoff
+	}
+
+	if option.MaxBackoff <= 0 {
+		option.MaxBackoff = defaultMaxBackoff
+	}
+
+	if option.Timeout <= 0 {
+		option.Timeout = defaultTimeout
+	}
+
+	if option.Workers < 1 {
+		option.Workers = 1
+	}
+
+	if option.QueueName == "" {
+		option.QueueName = defaultQueueName
+	}
+
+	q := option.Queue
+	if q == nil {
+		q = queue.NewQueue()
+	}
+
+	client := option.Client
+	if client == nil {
+		client = &http.Client{Timeout: option.Timeout}
+	}
+
+	return &Forwarder{
+		option:     option,
+		queue:      q,
+		client:     client,
+		pending:    option.QueueName + ":pending",
+		deadLetter: option.QueueName + ":dead",
+	}
+}
+
+// Forward enqueue event for every endpoint which accept its type.
+// event id and time filled when empty
+func (f *Forwarder) Forward(e Event) error {
+	if len(f.option.Endpoints) == 0 {
+		return ErrNoEndpoint
+	}
+
+	if e.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		e.ID = id.String()
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range f.option.Endpoints {
+		if !endpoint.accept(e.Type) {
+			continue
+		}
+
+		if err := f.push(f.pending, delivery{
+			URL:  endpoint.URL,
+			ID:   e.ID,
+			Type: e.Type,
+			Body: body,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run deliver queued events until context cancelled
+func (f *Forwarder) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < f.option.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// work take pending delivery one at a time
+func (f *Forwarder) work(ctx context.Context) {
+	for {
+		d, ok := f.pop(f.pending)
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultPollInterval):
+				continue
+			}
+		}
+
+		if err := f.deliver(ctx, &d); err != nil {
+			// interrupted by shutdown, keep for next run
+			if ctx.Err() != nil {
+				f.push(f.pending, d)
+				return
+			}
+
+			log.Printf("webhook %s to %s failed after %d attempts: %v\n", d.ID, d.URL, d.Attempts, err)
+
+			d.Error = err.Error()
+			f.push(f.deadLetter, d)
+		}
+	}
+}
+
+// deliver post event, retry with backoff until succeeded
+// or max attempts reached
+func (f *Forwarder) deliver(ctx context.Context, d *delivery) error {
+	backoff := f.option.Backoff
+
+	for {
+		d.Attempts++
+
+		err := f.post(ctx, d)
+		if err == nil || d.Attempts >= f.option.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > f.option.MaxBackoff {
+			backoff = f.option.MaxBackoff
+		}
+	}
+}
+
+// post send event body to endpoint, any non 2xx status
+// considered failure
+func (f *Forwarder) post(ctx context.Context, d *delivery) error {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Type)
+	req.Header.Set(HeaderID, d.ID)
+
+	// secret looked up from configuration, so it never stored in queue
+	if secret := f.secret(d.URL); secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, d.Body))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: status %d", ErrDeliveryRejected, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// secret return signing key of endpoint url
+func (f *Forwarder) secret(url string) string {
+	for _, endpoint := range f.option.Endpoints {
+		if endpoint.URL == url {
+			return endpoint.Secret
+		}
+	}
+
+	return ""
+}
+
+// DeadLetters return failed deliveries, oldest first
+func (f *Forwarder) DeadLetters() []DeadLetter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// rotate whole queue to read without losing items
+	var letters []DeadLetter
+	for i, n := 0, f.queue.Len(f.deadLetter); i < n; i++ {
+		d, ok := f.pop(f.deadLetter)
+		if !ok {
+			break
+		}
+
+		letters = append(letters, DeadLetter{
+			ID:       d.ID,
+			Type:     d.Type,
+			URL:      d.URL,
+			Attempts: d.Attempts,
+			Error:    d.Error,
+			Body:     d.Body,
+		})
+
+		f.push(f.deadLetter, d)
+	}
+
+	return letters
+}
+
+// Replay move dead letters back to pending queue with
+// fresh attempts, return number of replayed deliveries
+func (f *Forwarder) Replay() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var n int
+	for f.queue.Len(f.deadLetter) > 0 {
+		d, ok := f.pop(f.deadLetter)
+		if !ok {
+			break
+		}
+
+		d.Attempts = 0
+		d.Error = ""
+
+		if err := f.push(f.pending, d); err != nil {
+			log.Printf("failed to replay webhook %s: %v\n", d.ID, err)
+			f.push(f.deadLetter, d)
+			break
+		}
+
+		n++
+	}
+
+	return n
+}
+
+func (f *Forwarder) push(name string, d delivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return f.queue.Push(name, string(b))
+}
+
+// pop take oldest delivery, false if queue empty.
+// malformed item is dropped
+func (f *Forwarder) pop(name string) (delivery, bool) {
+	if f.queue.Len(name) == 0 {
+		return delivery{}, false
+	}
+
+	item, err := f.queue.Pop(name)
+	if err != nil {
+		return delivery{}, false
+	}
+
+	var d delivery
+	if err := json.Unmarshal([]byte(item), &d); err != nil {
+		log.Printf("dropped malformed webhook delivery: %v\n", err)
+		return delivery{}, false
+	}
+
+	return d, true
+}
+
+// Sign return signature header value of body,
+// receiver verify by computing same HMAC using shared secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify check signature header value against body
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
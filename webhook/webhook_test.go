@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/go-zk/push"
+	"github.com/galihrivanto/go-zk/queue"
+)
+
+func TestForwardSigned(t *testing.T) {
+	received := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- Verify("secret", body, r.Header.Get(HeaderSignature))
+	}))
+	defer srv.Close()
+
+	f := New(&Option{
+		Endpoints: []Endpoint{{URL: srv.URL, Secret: "secret"}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	if err := f.Forward(Event{Type: "attendance", SN: "123456789"}); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	select {
+	case valid := <-received:
+		if !valid {
+			t.Error("invalid signature")
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("event not delivered")
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	var calls, healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	f := New(&Option{
+		Endpoints:   []Endpoint{{URL: srv.URL}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	})
+
+	f.Forward(Event{Type: "attendance", SN: "123456789"})
+
+	// deliver single pending event
+	d, _ := f.pop(f.pending)
+	if err := f.deliver(context.Background(), &d); err == nil {
+		t.Error("expected delivery failure")
+		t.FailNow()
+	}
+	d.Error = "failed"
+	f.push(f.deadLetter, d)
+
+	if calls != 3 {
+		t.Errorf("expected 3 attempts but returned %d", calls)
+		t.FailNow()
+	}
+
+	letters := f.DeadLetters()
+	if len(letters) != 1 || letters[0].Attempts != 3 {
+		t.Errorf("unexpected dead letters %+v", letters)
+		t.FailNow()
+	}
+
+	// endpoint recovered
+	atomic.StoreInt32(&healthy, 1)
+
+	if n := f.Replay(); n != 1 {
+		t.Errorf("expected 1 replayed but returned %d", n)
+		t.FailNow()
+	}
+
+	d, _ = f.pop(f.pending)
+	if err := f.deliver(context.Background(), &d); err != nil || d.Attempts != 1 {
+		t.Errorf("expected replay delivered on first attempt but returned %v, %d", err, d.Attempts)
+	}
+}
+
+// gateQueue hold push while locked, like slow redis
+type gateQueue struct {
+	queue.Queuer
+	sync.Mutex
+}
+
+func (q *gateQueue) Push(name string, item string) error {
+	q.Lock()
+	q.Unlock()
+
+	return q.Queuer.Push(name, item)
+}
+
+func TestForwardPushBurst(t *testing.T) {
+	q := &gateQueue{Queuer: queue.NewQueue()}
+	f := New(&Option{
+		Endpoints: []Endpoint{{URL: "http://webhook.local"}},
+		Queue:     q,
+	})
+
+	bus := push.NewEventBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.ForwardPush(ctx, bus, push.EventFilter{})
+
+	// wait subscribed
+	for i := 0; i < 100 && q.Len(f.pending) == 0; i++ {
+		bus.Publish(push.Event{Type: push.EventAttendance, SN: "probe"})
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for q.Len(f.pending) > 0 {
+		f.pop(f.pending)
+	}
+
+	const burst = 200
+
+	q.Lock()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < burst; i++ {
+			bus.Publish(push.Event{Type: push.EventAttendance, SN: "123456789"})
+		}
+		close(done)
+	}()
+
+	// publisher wait for forwarder instead of dropping
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 100):
+	}
+	q.Unlock()
+	<-done
+
+	for i := 0; i < 100 && q.Len(f.pending) < burst; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if n := q.Len(f.pending); n != burst {
+		t.Errorf("expected %d events forwarded but returned %d", burst, n)
+	}
+}